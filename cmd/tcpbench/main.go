@@ -0,0 +1,82 @@
+// tcpbench 对 TCP 服务做压力测试，报告往返时延的百分位数、吞吐量和连接错误。
+//
+//	tcpbench -addr 127.0.0.1:8080 -c 100 -d 30s -framing length -size 128
+//
+// 每个连接发送一个帧，收到一个响应帧之后再发送下一个。要压测同一个进程里的 tcp.Server ，直接使用 bench 包。
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"flag"
+	"fmt"
+	"github.com/seedjyh/go-tcp/pkg/tcp"
+	"github.com/seedjyh/go-tcp/pkg/tcp/bench"
+	"os"
+	"os/signal"
+	"time"
+)
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:8080", "被测服务的地址")
+	connections := flag.Int("c", 10, "同时保持的连接数")
+	duration := flag.Duration("d", 10*time.Second, "运行时间。0表示直到每个连接发送完 -n 个帧")
+	requests := flag.Int("n", 0, "每个连接发送的帧数。0表示不限制")
+	timeout := flag.Duration("timeout", bench.DefaultTimeout, "建立连接和等待每个响应的时限")
+	framing := flag.String("framing", "length", "帧的格式：length （4字节大端序长度前缀）、 line （换行结尾）或 raw （收到的任何字节都算作响应）")
+	maxSize := flag.Int("max-size", 1<<20, "framing 是 length 时，响应内容的最大字节数")
+	payload := flag.String("payload", "", "每个帧的内容。为空时使用 -size 字节的随机内容")
+	size := flag.Int("size", 64, "随机内容的字节数")
+	flag.Parse()
+
+	config := bench.Config{
+		Address:     *addr,
+		Connections: *connections,
+		Duration:    *duration,
+		Requests:    *requests,
+		Timeout:     *timeout,
+	}
+	if *payload != "" {
+		config.Generator = bench.Fixed([]byte(*payload))
+	} else {
+		random := make([]byte, *size)
+		_, _ = rand.Read(random)
+		if *framing == "line" {
+			// 随机内容里不能有换行
+			random = bytes.ReplaceAll(random, []byte{'\n'}, []byte{' '})
+		}
+		config.Generator = bench.Fixed(random)
+	}
+	switch *framing {
+	case "length":
+		config.Framer = tcp.LengthPrefixFramer
+		config.Splitter = tcp.LengthPrefixSplitter(*maxSize)
+	case "line":
+		config.Framer = func(payload []byte) []byte {
+			return append(append([]byte(nil), payload...), '\n')
+		}
+		config.Splitter = func(buf []byte) (*tcp.Packet, int, error) {
+			if i := bytes.IndexByte(buf, '\n'); i >= 0 {
+				return tcp.NewPacket(buf[:i]), i + 1, nil
+			}
+			return nil, 0, tcp.NoEnoughData
+		}
+	case "raw":
+	default:
+		fmt.Fprintf(os.Stderr, "unknown framing %q\n", *framing)
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	result, err := bench.Run(ctx, config)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	fmt.Print(result)
+	if len(result.Errors) > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,91 @@
+// tcpreplay 查看或回放 capture 包保存的抓包文件。
+//
+// 查看：
+//
+//	tcpreplay capture.jsonl
+//
+// 把每个会话收到的帧发送到正在运行的服务，比较响应和记录里发送的帧：
+//
+//	tcpreplay -addr 127.0.0.1:8080 capture.jsonl
+//
+// 有差异时退出码是1。
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/seedjyh/go-tcp/pkg/tcp"
+	"github.com/seedjyh/go-tcp/pkg/tcp/capture"
+	"net"
+	"os"
+	"time"
+)
+
+func main() {
+	addr := flag.String("addr", "", "回放到这个地址。为空时只打印抓包文件的内容")
+	connection := flag.String("connection", "", "只处理这个连接 ID 的会话")
+	timeout := flag.Duration("timeout", time.Second, "等待每个响应的时限")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] capture.jsonl\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	sessions, err := load(flag.Arg(0), tcp.ConnectionID(*connection))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if *addr == "" {
+		dump(sessions)
+		return
+	}
+	mismatches, err := capture.Replay(func() (net.Conn, error) {
+		return net.DialTimeout("tcp", *addr, *timeout)
+	}, sessions, *timeout)
+	for _, m := range mismatches {
+		fmt.Println(m)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Printf("%d sessions replayed, %d mismatched\n", len(sessions), len(mismatches))
+	if len(mismatches) > 0 {
+		os.Exit(1)
+	}
+}
+
+func load(path string, connection tcp.ConnectionID) ([]*capture.Session, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	records, err := capture.Read(f)
+	if err != nil {
+		return nil, err
+	}
+	sessions := capture.Sessions(records)
+	if connection == "" {
+		return sessions, nil
+	}
+	for _, session := range sessions {
+		if session.ConnectionID == connection {
+			return []*capture.Session{session}, nil
+		}
+	}
+	return nil, fmt.Errorf("no session of connection %s", connection)
+}
+
+func dump(sessions []*capture.Session) {
+	for _, session := range sessions {
+		fmt.Printf("connection %s\n", session.ConnectionID)
+		for _, record := range session.Records {
+			fmt.Printf("  %s %-3s %5d %q\n", record.Time.Format("15:04:05.000000"), record.Direction, len(record.Data), record.Data)
+		}
+	}
+}
@@ -7,7 +7,6 @@ import (
 	"fmt"
 	"github.com/seedjyh/go-tcp/pkg/tcp"
 	"golang.org/x/sync/errgroup"
-	"sync"
 	"time"
 )
 
@@ -21,68 +20,37 @@ func mySplitter(buf []byte) (*tcp.Packet, int, error) {
 	return nil, 0, tcp.NoEnoughData
 }
 
-type Envelope struct {
-	connID tcp.ConnectionID
-	data   tcp.ReceivedMessage
-}
-
-func NewEnvelope(connID tcp.ConnectionID, data tcp.ReceivedMessage) *Envelope {
-	return &Envelope{
-		connID: connID,
-		data:   data,
-	}
-}
-
 func main() {
 
 	// 这里创建了一个服务器。监听 port 端口，接受任何连接（可以用telnet连接）。
 
 	// 指定端口
 	port := 11223
-	inSiteChannel := make(chan *Envelope)                                   // 收到的消息
-	outSiteChannelMap := make(map[tcp.ConnectionID]chan tcp.SendingMessage) // 要发送的消息
-	outSiteChannelMapMutex := sync.RWMutex{}
+
+	// 所有连接共享的协程池：16个 worker ，每个 worker 最多排队64条消息。
+	// 同一个连接的消息按收到的顺序处理；协程池满时，服务器会暂停从连接收取数据。
+	pool := tcp.NewWorkerPool(16, 64)
+	defer pool.Stop()
 
 	s := tcp.NewServer()
 
-	// 设置分包规则：每5个字节一个包。
+	// 设置分包规则：遇到换行符就截断。
 	s.SetSplitter(mySplitter)
 
-	s.SetOnConnected(func(connectionID tcp.ConnectionID) (outSiteMessageBus <-chan tcp.SendingMessage) {
-		outSiteChannelMapMutex.Lock()
-		defer outSiteChannelMapMutex.Unlock()
-		if ch, ok := outSiteChannelMap[connectionID]; ok {
-			return ch
-		} else {
-			ch := make(chan tcp.SendingMessage)
-			outSiteChannelMap[connectionID] = ch
-			return ch
+	s.SetDefaultHandler(pool.Handler(tcp.ByConnectionID, func(c tcp.Context) error {
+		select {
+		case <-time.After(time.Second):
+		case <-c.Context().Done(): // 连接已经中断
+			return c.Context().Err()
 		}
-	})
-	s.SetOnDisconnected(func(connectionID tcp.ConnectionID) {
-		outSiteChannelMapMutex.Lock()
-		defer outSiteChannelMapMutex.Unlock()
-		delete(outSiteChannelMap, connectionID)
-	})
-
-	s.SetDefaultHandler(func(c tcp.Context) error {
-		inSiteChannel <- NewEnvelope(c.ConnectionID(), c.Received())
+		c.Send(tcp.NewPacket([]byte(fmt.Sprintf("got: %s", c.Received().(*tcp.Packet).Bytes()))))
 		return nil
-	})
+	}))
 
 	// start
 	eg, ctx := errgroup.WithContext(context.Background())
 	eg.Go(func() error { return s.Start(fmt.Sprintf("0.0.0.0:%d", port)) })
 
-	for m := range inSiteChannel {
-		m := m
-		time.AfterFunc(time.Second, func() {
-			//outSiteChannelMapMutex.RLocker()
-			//defer outSiteChannelMapMutex.RUnlock()
-			outSiteChannelMap[m.connID] <- tcp.NewPacket([]byte(fmt.Sprintf("got: %+v", m.data)))
-		})
-	}
-
 	// wait
 	<-ctx.Done()
 
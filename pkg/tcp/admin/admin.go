@@ -0,0 +1,190 @@
+// Package admin 提供查看和管理 tcp.Server 的 HTTP 接口。
+// 它能看到所有连接的地址和 visible 的会话值，也能中断连接和让服务下线，所以只应该挂在内网或者有认证的地址上。
+//
+//	http.Handle("/admin/", http.StripPrefix("/admin", admin.NewHandler(s)))
+//
+// 接口都返回 JSON ：
+//
+//	GET  /                             服务状态和所有连接
+//	GET  /connections/{id}             一个连接
+//	POST /connections/{id}/disconnect  中断一个连接，见 tcp.Server.Disconnect
+//	POST /drain?timeout=30s            不再接受新连接，等待已有连接结束，见 tcp.Server.Drain
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/seedjyh/go-tcp/pkg/tcp"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Handler 是管理接口的 http.Handler 。
+type Handler struct {
+	server *tcp.Server
+}
+
+func NewHandler(server *tcp.Server) *Handler {
+	return &Handler{server: server}
+}
+
+// Status 是 GET / 的响应。
+type Status struct {
+	State       tcp.ServerState `json:"state"`
+	Address     string          `json:"address"`
+	Connections []Connection    `json:"connections"`
+}
+
+// Connection 是一个连接的状态。
+type Connection struct {
+	ID             tcp.ConnectionID       `json:"id"`
+	RemoteAddr     string                 `json:"remote_addr"`
+	LocalAddr      string                 `json:"local_addr"`
+	ConnectedAt    time.Time              `json:"connected_at"`
+	Age            string                 `json:"age"`
+	BytesReceived  int64                  `json:"bytes_received"`
+	BytesSent      int64                  `json:"bytes_sent"`
+	FramesReceived int64                  `json:"frames_received"`
+	FramesSent     int64                  `json:"frames_sent"`
+	SendQueueDepth int                    `json:"send_queue_depth"`
+	Principal      interface{}            `json:"principal,omitempty"`
+	Values         map[string]interface{} `json:"values,omitempty"`
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(r.URL.Path, "/")
+	switch {
+	case path == "":
+		if method(w, r, http.MethodGet) {
+			h.status(w)
+		}
+	case path == "drain":
+		if method(w, r, http.MethodPost) {
+			h.drain(w, r)
+		}
+	case strings.HasPrefix(path, "connections/"):
+		id, action, _ := strings.Cut(strings.TrimPrefix(path, "connections/"), "/")
+		switch action {
+		case "":
+			if method(w, r, http.MethodGet) {
+				h.connection(w, tcp.ConnectionID(id))
+			}
+		case "disconnect":
+			if method(w, r, http.MethodPost) {
+				h.disconnect(w, tcp.ConnectionID(id))
+			}
+		default:
+			writeError(w, http.StatusNotFound, "not found")
+		}
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (h *Handler) status(w http.ResponseWriter) {
+	status := h.server.Status()
+	now := time.Now()
+	connections := make([]Connection, 0)
+	for _, stats := range h.server.Connections() {
+		connections = append(connections, newConnection(stats, now))
+	}
+	writeJSON(w, http.StatusOK, Status{State: status.State, Address: status.Address, Connections: connections})
+}
+
+func (h *Handler) connection(w http.ResponseWriter, id tcp.ConnectionID) {
+	for _, stats := range h.server.Connections() {
+		if stats.ConnectionID == id {
+			writeJSON(w, http.StatusOK, newConnection(stats, time.Now()))
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, "no such connection")
+}
+
+func (h *Handler) disconnect(w http.ResponseWriter, id tcp.ConnectionID) {
+	if !h.server.Disconnect(id) {
+		writeError(w, http.StatusNotFound, "no such connection")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"result": "disconnected"})
+}
+
+func (h *Handler) drain(w http.ResponseWriter, r *http.Request) {
+	var timeout time.Duration
+	if s := r.URL.Query().Get("timeout"); s != "" {
+		var err error
+		if timeout, err = time.ParseDuration(s); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	if err := h.server.Drain(timeout); err != nil {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusAccepted, map[string]string{"result": "draining"})
+}
+
+func newConnection(stats tcp.ConnectionStats, now time.Time) Connection {
+	c := Connection{
+		ID:             stats.ConnectionID,
+		RemoteAddr:     addrString(stats.RemoteAddr),
+		LocalAddr:      addrString(stats.LocalAddr),
+		ConnectedAt:    stats.ConnectedAt,
+		Age:            now.Sub(stats.ConnectedAt).Round(time.Second).String(),
+		BytesReceived:  stats.BytesReceived,
+		BytesSent:      stats.BytesSent,
+		FramesReceived: stats.FramesReceived,
+		FramesSent:     stats.FramesSent,
+		SendQueueDepth: stats.SendQueueDepth,
+		Principal:      jsonValue(stats.Principal),
+	}
+	if len(stats.Values) > 0 {
+		c.Values = make(map[string]interface{}, len(stats.Values))
+		for key, value := range stats.Values {
+			c.Values[key] = jsonValue(value)
+		}
+	}
+	return c
+}
+
+// jsonValue 返回能编码成 JSON 的 v ，不能编码时用 fmt.Sprint 转成字符串。
+func jsonValue(v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	if _, err := json.Marshal(v); err != nil {
+		return fmt.Sprint(v)
+	}
+	return v
+}
+
+func addrString(addr interface{ String() string }) string {
+	if addr == nil {
+		return ""
+	}
+	return addr.String()
+}
+
+// method 检查请求的方法，不对时返回 405 。
+func method(w http.ResponseWriter, r *http.Request, want string) bool {
+	if r.Method != want {
+		w.Header().Set("Allow", want)
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	_ = encoder.Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, message string) {
+	writeJSON(w, code, map[string]string{"error": message})
+}
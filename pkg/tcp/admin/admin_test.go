@@ -0,0 +1,137 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/seedjyh/go-tcp/pkg/tcp"
+	"github.com/seedjyh/go-tcp/pkg/tcp/tcptest"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newServer() *tcp.Server {
+	s := tcp.NewServer()
+	s.SetLogHandler(slog.NewTextHandler(io.Discard, nil))
+	s.SetDefaultHandler(func(c tcp.Context) error {
+		c.Authenticate("alice")
+		c.SetValue("room", "lobby", true)
+		c.SetValue("token", "secret", false)
+		c.Send(tcp.NewPacket([]byte("ok")))
+		return nil
+	})
+	return s
+}
+
+// frameSentCollector 每发送完一个包向 sent 写入一次。
+type frameSentCollector struct {
+	tcp.NopMetricsCollector
+	sent chan struct{}
+}
+
+func (c frameSentCollector) FrameSent() {
+	c.sent <- struct{}{}
+}
+
+func serve(h http.Handler, method, target string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+	return w
+}
+
+func TestHandler(t *testing.T) {
+	s := newServer()
+	metrics := frameSentCollector{sent: make(chan struct{}, 1)}
+	s.SetMetricsCollector(metrics)
+	ts := tcptest.NewServer(s)
+	defer ts.Close()
+	client, err := ts.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if err := client.Write([]byte("login")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.NextFrame(); err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandler(s)
+
+	// Sender 写完之后才更新统计，客户端可能先收到响应，等 FrameSent 报告之后再查询
+	<-metrics.sent
+	w := serve(h, http.MethodGet, "/")
+	var status Status
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatal(err, w.Body.String())
+	}
+	if status.State != tcp.StateServing || len(status.Connections) != 1 {
+		t.Fatalf("got %s", w.Body.String())
+	}
+	c := status.Connections[0]
+	if c.RemoteAddr != client.Conn().LocalAddr().String() || c.BytesReceived != 5 || c.FramesSent != 1 ||
+		c.Principal != "alice" || c.Values["room"] != "lobby" || c.Values["token"] != nil {
+		t.Errorf("got %s", w.Body.String())
+	}
+
+	if w := serve(h, http.MethodGet, "/connections/"+string(c.ID)); w.Code != http.StatusOK {
+		t.Errorf("get connection: %d", w.Code)
+	}
+	if w := serve(h, http.MethodGet, "/connections/"+string(c.ID)+"/disconnect"); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("disconnect with GET: %d", w.Code)
+	}
+	if w := serve(h, http.MethodPost, "/connections/"+string(c.ID)+"/disconnect"); w.Code != http.StatusOK {
+		t.Errorf("disconnect: %d %s", w.Code, w.Body.String())
+	}
+	if reason, err := client.WaitClosed(); err != nil || !errors.Is(reason, tcp.ConnectionDisconnected) {
+		t.Errorf("got reason %v, %v", reason, err)
+	}
+	if w := serve(h, http.MethodPost, "/connections/"+string(c.ID)+"/disconnect"); w.Code != http.StatusNotFound {
+		t.Errorf("disconnect again: %d", w.Code)
+	}
+}
+
+func TestHandler_drain(t *testing.T) {
+	s := newServer()
+	ts := tcptest.NewServer(s)
+	client, err := ts.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.NextFrame(); err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandler(s)
+	if w := serve(h, http.MethodPost, "/drain?timeout=1m"); w.Code != http.StatusAccepted {
+		t.Fatalf("drain: %d %s", w.Code, w.Body.String())
+	}
+	if w := serve(h, http.MethodPost, "/drain"); w.Code != http.StatusConflict {
+		t.Errorf("drain again: %d", w.Code)
+	}
+	if _, err := ts.Dial(); err == nil {
+		t.Error("new connection accepted while draining")
+	}
+	// 已有的连接不受影响
+	if err := client.Write([]byte("still here")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.NextFrame(); err != nil {
+		t.Fatal(err)
+	}
+	if w := serve(h, http.MethodGet, "/"); !strings.Contains(w.Body.String(), `"state": "draining"`) {
+		t.Errorf("got %s", w.Body.String())
+	}
+	_ = client.Close()
+	if err := ts.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if status := s.Status(); status.State != tcp.StateStopped || status.Connections != 0 {
+		t.Errorf("got %+v", status)
+	}
+}
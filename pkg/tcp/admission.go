@@ -0,0 +1,139 @@
+package tcp
+
+import (
+	"crypto/tls"
+	"github.com/pkg/errors"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AdmissionDenied 是 IPFilter 拒绝连接时返回的错误。
+var AdmissionDenied = errors.New("connection denied")
+
+// TLSHandshakeTimeout 是 Server 在调用 AdmissionFunc 之前完成 TLS 握手的时限。
+const TLSHandshakeTimeout = time.Second * 10
+
+// 连接被拒绝的原因，用作 MetricsCollector.ConnectionRejected 的参数。
+const (
+	RejectReasonAdmission    = "admission"
+	RejectReasonTLSHandshake = "tls_handshake"
+	RejectReasonConnectionID = "connection_id" // FallibleGenerator 生成连接 ID 出错
+)
+
+// ConnectionInfo 描述一个刚接入、还没有开始处理的连接。
+type ConnectionInfo struct {
+	ConnectionID ConnectionID
+	RemoteAddr   net.Addr
+	LocalAddr    net.Addr
+	TLS          *tls.ConnectionState // 不是 TLS 连接时为 nil
+	Proxy        *ProxyHeader         // 没有 PROXY protocol 头时为 nil 。此时 RemoteAddr 是代理的地址
+}
+
+// AdmissionFunc 决定是否接受一个新连接。返回错误表示拒绝，连接会被立刻关闭，不会回调 OnConnectedFunc 。
+// 同时到达的多个连接会并发调用它。
+type AdmissionFunc func(info *ConnectionInfo) error
+
+// IPFilter 按远端 IP 的 CIDR 允许列表和拒绝列表检查连接。可以在运行中用 Reload 替换列表。
+// 规则：命中拒绝列表的拒绝；允许列表为空时接受其他所有连接，否则只接受命中允许列表的连接。
+type IPFilter struct {
+	mutex sync.RWMutex
+	allow []netip.Prefix
+	deny  []netip.Prefix
+}
+
+// NewIPFilter 创建一个 IPFilter 。列表里的每一项是 CIDR（如 "10.0.0.0/8"）或单个 IP（如 "192.168.1.1"）。
+func NewIPFilter(allow []string, deny []string) (*IPFilter, error) {
+	f := &IPFilter{}
+	if err := f.Reload(allow, deny); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Reload 替换允许列表和拒绝列表。任何一项格式错误时返回错误，不修改原有列表。
+func (f *IPFilter) Reload(allow []string, deny []string) error {
+	allowPrefixes, err := parsePrefixes(allow)
+	if err != nil {
+		return err
+	}
+	denyPrefixes, err := parsePrefixes(deny)
+	if err != nil {
+		return err
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.allow = allowPrefixes
+	f.deny = denyPrefixes
+	return nil
+}
+
+// Admit 是一个 AdmissionFunc 。
+func (f *IPFilter) Admit(info *ConnectionInfo) error {
+	ip, err := addrIP(info.RemoteAddr)
+	if err != nil {
+		return errors.Wrap(AdmissionDenied, err.Error())
+	}
+	if !f.Allowed(ip) {
+		return errors.Wrapf(AdmissionDenied, "ip %s", ip)
+	}
+	return nil
+}
+
+// Allowed 返回 ip 是否被允许。
+func (f *IPFilter) Allowed(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	if containsIP(f.deny, ip) {
+		return false
+	}
+	return len(f.allow) == 0 || containsIP(f.allow, ip)
+}
+
+func containsIP(prefixes []netip.Prefix, ip netip.Addr) bool {
+	for _, p := range prefixes {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func parsePrefixes(items []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(items))
+	for _, item := range items {
+		item = strings.TrimSpace(item)
+		if strings.Contains(item, "/") {
+			p, err := netip.ParsePrefix(item)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, p.Masked())
+		} else {
+			ip, err := netip.ParseAddr(item)
+			if err != nil {
+				return nil, err
+			}
+			ip = ip.Unmap()
+			prefixes = append(prefixes, netip.PrefixFrom(ip, ip.BitLen()))
+		}
+	}
+	return prefixes, nil
+}
+
+// addrIP 取出 addr 里的 IP 。
+func addrIP(addr net.Addr) (netip.Addr, error) {
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		if ip, ok := netip.AddrFromSlice(tcpAddr.IP); ok {
+			return ip.Unmap(), nil
+		}
+	}
+	ap, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	return ap.Addr().Unmap(), nil
+}
@@ -0,0 +1,138 @@
+package tcp
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestIPFilter_Allowed(t *testing.T) {
+	f, err := NewIPFilter([]string{"10.0.0.0/8", "192.168.1.1", "2001:db8::/32"}, []string{"10.1.0.0/16"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ip, want := range map[string]bool{
+		"10.2.3.4":         true,
+		"10.1.2.3":         false,
+		"192.168.1.1":      true,
+		"192.168.1.2":      false,
+		"::ffff:10.2.3.4":  true,
+		"2001:db8::1":      true,
+		"2001:db9::1":      false,
+		"172.16.0.1":       false,
+		"::ffff:10.1.0.10": false,
+	} {
+		if got := f.Allowed(netip.MustParseAddr(ip)); got != want {
+			t.Errorf("%s: got %v, want %v", ip, got, want)
+		}
+	}
+	if err := f.Reload(nil, []string{"bad"}); err == nil {
+		t.Error("reload with a bad item should fail")
+	}
+	if !f.Allowed(netip.MustParseAddr("10.2.3.4")) {
+		t.Error("failed reload should keep the old lists")
+	}
+	if err := f.Reload(nil, []string{"10.2.3.4"}); err != nil {
+		t.Fatal(err)
+	}
+	if f.Allowed(netip.MustParseAddr("10.2.3.4")) || !f.Allowed(netip.MustParseAddr("172.16.0.1")) {
+		t.Error("reload did not take effect")
+	}
+}
+
+type rejectCounter struct {
+	NopMetricsCollector
+	rejected chan string
+	accepted atomic.Int64
+}
+
+func (c *rejectCounter) ConnectionAccepted() {
+	c.accepted.Add(1)
+}
+
+func (c *rejectCounter) ConnectionRejected(reason string) {
+	c.rejected <- reason
+}
+
+func TestServer_SetAdmission(t *testing.T) {
+	filter, _ := NewIPFilter(nil, []string{"127.0.0.1"})
+	connected := false
+	metrics := &rejectCounter{rejected: make(chan string, 1)}
+	s := NewServer()
+	s.SetLogHandler(slog.NewTextHandler(io.Discard, nil))
+	s.SetMetricsCollector(metrics)
+	s.SetAdmission(filter.Admit)
+	s.SetOnConnected(func(connectionID ConnectionID) <-chan SendingMessage {
+		connected = true
+		return nil
+	})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() { done <- s.Serve(ln) }()
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 1)); !errors.Is(err, io.EOF) {
+		t.Errorf("rejected connection: read got %v, want EOF", err)
+	}
+	if reason := <-metrics.rejected; reason != RejectReasonAdmission {
+		t.Errorf("reject reason %q", reason)
+	}
+	_ = s.Stop()
+	<-done
+	if connected {
+		t.Error("OnConnected called for a rejected connection")
+	}
+	if n := metrics.accepted.Load(); n != 0 {
+		t.Errorf("rejected connection counted as accepted %d times", n)
+	}
+}
+
+// failingGenerator 的 Generate 总是出错。
+type failingGenerator struct{}
+
+func (failingGenerator) Next() string {
+	panic("Next should not be called on a FallibleGenerator")
+}
+
+func (failingGenerator) Generate() (string, error) {
+	return "", errors.New("no entropy")
+}
+
+func TestServer_connectionIDError(t *testing.T) {
+	metrics := &rejectCounter{rejected: make(chan string, 1)}
+	closed := make(chan error, 1)
+	s := NewServer()
+	s.SetLogHandler(slog.NewTextHandler(io.Discard, nil))
+	s.SetMetricsCollector(metrics)
+	s.SetDefaultConnectionIDGenerator(failingGenerator{})
+	s.SetOnClosed(func(info *ConnectionInfo, reason error) {
+		closed <- reason
+	})
+	client, server := net.Pipe()
+	defer client.Close()
+	done := make(chan error)
+	go func() { done <- s.Serve(newPipeListener(server)) }()
+	if reason := <-metrics.rejected; reason != RejectReasonConnectionID {
+		t.Errorf("reject reason %q", reason)
+	}
+	if reason := <-closed; reason == nil || reason.Error() != "no entropy" {
+		t.Errorf("closed with %v", reason)
+	}
+	_ = s.Stop()
+	<-done
+	if n := metrics.accepted.Load(); n != 0 {
+		t.Errorf("rejected connection counted as accepted %d times", n)
+	}
+}
@@ -0,0 +1,211 @@
+package tcp
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"sync"
+)
+
+var (
+	// FrameAuthenticationFailed 帧的认证标签不对，可能被篡改或者密钥不对
+	FrameAuthenticationFailed = errors.New("frame authentication failed")
+	// ReplayedFrame 帧的序号已经收到过，或者落在重放窗口之外
+	ReplayedFrame = errors.New("replayed frame")
+)
+
+const (
+	aeadKeyIDSize    = 4
+	aeadSequenceSize = 8
+	aeadNonceSize    = 12
+	// AEADOverhead 是 AEAD 编码后增加的字节数：keyID 、序号、nonce 和认证标签。
+	AEADOverhead = aeadKeyIDSize + aeadSequenceSize + aeadNonceSize + 16
+	// DefaultAEADRotateAfter 是 AEADConfig.RotateAfter 的默认值。随机 nonce 的 AES-GCM 每个密钥不应加密超过 2^32 帧。
+	DefaultAEADRotateAfter = 1 << 30
+)
+
+// AEADConfig 是 AEAD 编解码的配置。
+type AEADConfig struct {
+	// Keys 返回 keyID 对应的密钥，长度是16、24或32字节，分别对应 AES-128 、 AES-192 、 AES-256 。
+	// 收到帧时按帧头里的 keyID 查找，所以对端可以独立地换用新密钥。
+	Keys func(connectionID ConnectionID, keyID uint32) ([]byte, error)
+	// SendKeyID 是新连接发送时使用的 keyID 。
+	SendKeyID uint32
+	// RotateAfter 是一个密钥最多发送的帧数，达到后调用 NextKeyID 换用新密钥。0表示 DefaultAEADRotateAfter 。
+	RotateAfter uint64
+	// NextKeyID 返回下一个发送用的 keyID 。nil 表示达到 RotateAfter 时发送失败，连接会被关闭。
+	NextKeyID func(connectionID ConnectionID, current uint32) (uint32, error)
+}
+
+// AEAD 返回用 AES-GCM 加密和认证帧内容的 CodecFactory ，每个连接一个 *AEADCodec 。
+//
+// 编码后的帧内容是 keyID(4字节) + 序号(8字节) + nonce(12字节) + 密文和认证标签，整数都是大端序。
+// keyID 和序号也被认证。nonce 是随机的，所以多个连接共享同一个预共享密钥也不会重复。
+// 序号在每个连接的每个方向上从0开始递增，接收方用它检查重放。
+// 重放检查只在一个连接内有效；要防止把一个连接的帧重放到新连接上，需要在握手里加入挑战（见 Server.SetHandshake ）。
+func AEAD(config AEADConfig) CodecFactory {
+	if config.RotateAfter == 0 {
+		config.RotateAfter = DefaultAEADRotateAfter
+	}
+	return func(connectionID ConnectionID) FrameCodec {
+		return &AEADCodec{
+			config:       config,
+			connectionID: connectionID,
+			aeads:        make(map[uint32]cipher.AEAD),
+			sendKeyID:    config.SendKeyID,
+		}
+	}
+}
+
+// AEADCodec 是一个连接的 AEAD 编解码。处理函数可以用 Context.Codec 得到它，再用 Rotate 换用新密钥。
+type AEADCodec struct {
+	config       AEADConfig
+	connectionID ConnectionID
+	mutex        sync.Mutex
+	aeads        map[uint32]cipher.AEAD // 按 keyID 缓存
+	sendKeyID    uint32
+	sentWithKey  uint64 // 用 sendKeyID 发送的帧数
+	sendSequence uint64
+	window       replayWindow
+}
+
+// SendKeyID 返回当前发送用的 keyID 。
+func (c *AEADCodec) SendKeyID() uint32 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.sendKeyID
+}
+
+// Rotate 让之后发送的帧使用 keyID 对应的密钥。
+func (c *AEADCodec) Rotate(keyID uint32) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if _, err := c.aead(keyID); err != nil {
+		return err
+	}
+	c.sendKeyID = keyID
+	c.sentWithKey = 0
+	return nil
+}
+
+func (c *AEADCodec) Encode(payload []byte) ([]byte, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.sentWithKey >= c.config.RotateAfter {
+		if c.config.NextKeyID == nil {
+			return nil, errors.Errorf("key %d has sent %d frames", c.sendKeyID, c.sentWithKey)
+		}
+		if keyID, err := c.config.NextKeyID(c.connectionID, c.sendKeyID); err != nil {
+			return nil, err
+		} else if _, err := c.aead(keyID); err != nil {
+			return nil, err
+		} else {
+			c.sendKeyID = keyID
+			c.sentWithKey = 0
+		}
+	}
+	aead, err := c.aead(c.sendKeyID)
+	if err != nil {
+		return nil, err
+	}
+	headerSize := aeadKeyIDSize + aeadSequenceSize + aeadNonceSize
+	frame := make([]byte, headerSize, headerSize+len(payload)+aead.Overhead())
+	binary.BigEndian.PutUint32(frame, c.sendKeyID)
+	binary.BigEndian.PutUint64(frame[aeadKeyIDSize:], c.sendSequence)
+	nonce := frame[aeadKeyIDSize+aeadSequenceSize : headerSize]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	c.sendSequence++
+	c.sentWithKey++
+	return aead.Seal(frame, nonce, payload, frame[:aeadKeyIDSize+aeadSequenceSize]), nil
+}
+
+func (c *AEADCodec) Decode(frame []byte) ([]byte, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	headerSize := aeadKeyIDSize + aeadSequenceSize + aeadNonceSize
+	if len(frame) < headerSize {
+		return nil, errors.Wrap(BadMessageFormat, "aead frame too short")
+	}
+	keyID := binary.BigEndian.Uint32(frame)
+	sequence := binary.BigEndian.Uint64(frame[aeadKeyIDSize:])
+	aead, err := c.aead(keyID)
+	if err != nil {
+		return nil, err
+	}
+	if !c.window.check(sequence) {
+		return nil, errors.Wrapf(ReplayedFrame, "sequence %d", sequence)
+	}
+	payload, err := aead.Open(nil, frame[aeadKeyIDSize+aeadSequenceSize:headerSize], frame[headerSize:], frame[:aeadKeyIDSize+aeadSequenceSize])
+	if err != nil {
+		return nil, errors.Wrapf(FrameAuthenticationFailed, "key %d sequence %d", keyID, sequence)
+	}
+	// 认证通过之后才记录序号，避免伪造的帧推动窗口。
+	c.window.accept(sequence)
+	return payload, nil
+}
+
+// aead 返回 keyID 对应的 cipher.AEAD 。调用时要持有 c.mutex 。
+func (c *AEADCodec) aead(keyID uint32) (cipher.AEAD, error) {
+	if aead, ok := c.aeads[keyID]; ok {
+		return aead, nil
+	}
+	key, err := c.config.Keys(c.connectionID, keyID)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "key %d", keyID)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "key %d", keyID)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	c.aeads[keyID] = aead
+	return aead, nil
+}
+
+// replayWindowSize 是重放窗口的大小。比窗口里最大的序号小 replayWindowSize 以上的帧被拒绝。
+const replayWindowSize = 64
+
+// replayWindow 记录最近收到的序号。第 i 位表示序号 highest-i 是否收到过。
+type replayWindow struct {
+	started bool
+	highest uint64
+	bitmap  uint64
+}
+
+// check 返回 sequence 是否既没有收到过，也没有落在窗口之外。
+func (w *replayWindow) check(sequence uint64) bool {
+	if !w.started || sequence > w.highest {
+		return true
+	}
+	offset := w.highest - sequence
+	return offset < replayWindowSize && w.bitmap&(1<<offset) == 0
+}
+
+// accept 记录收到了 sequence 。调用前要先用 check 检查。
+func (w *replayWindow) accept(sequence uint64) {
+	if !w.started {
+		w.started = true
+		w.highest = sequence
+		w.bitmap = 1
+		return
+	}
+	if sequence > w.highest {
+		shift := sequence - w.highest
+		if shift >= replayWindowSize {
+			w.bitmap = 0
+		} else {
+			w.bitmap <<= shift
+		}
+		w.highest = sequence
+		w.bitmap |= 1
+		return
+	}
+	w.bitmap |= 1 << (w.highest - sequence)
+}
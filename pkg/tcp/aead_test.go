@@ -0,0 +1,77 @@
+package tcp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func testAEADKeys(connectionID ConnectionID, keyID uint32) ([]byte, error) {
+	if keyID > 2 {
+		return nil, fmt.Errorf("no key %d", keyID)
+	}
+	return bytes.Repeat([]byte{byte(keyID + 1)}, 32), nil
+}
+
+func TestAEADCodec(t *testing.T) {
+	rotated := 0
+	factory := AEAD(AEADConfig{
+		Keys:        testAEADKeys,
+		SendKeyID:   1,
+		RotateAfter: 3,
+		NextKeyID: func(connectionID ConnectionID, current uint32) (uint32, error) {
+			rotated++
+			return current%2 + 1, nil
+		},
+	})
+	client, server := factory("conn1"), factory("conn1")
+	var frames [][]byte
+	for i := 0; i < 5; i++ {
+		frame := mustEncode(t, client, []byte(fmt.Sprintf("frame %d", i)))
+		if len(frame) != len("frame 0")+AEADOverhead {
+			t.Errorf("frame size %d", len(frame))
+		}
+		frames = append(frames, frame)
+	}
+	if rotated != 1 || client.(*AEADCodec).SendKeyID() != 2 {
+		t.Errorf("rotated=%d key=%d", rotated, client.(*AEADCodec).SendKeyID())
+	}
+	// 乱序到达的帧在窗口内可以接受
+	for _, i := range []int{0, 2, 1, 4, 3} {
+		if payload, err := server.Decode(frames[i]); err != nil || string(payload) != fmt.Sprintf("frame %d", i) {
+			t.Errorf("frame %d: %q, %v", i, payload, err)
+		}
+	}
+	if _, err := server.Decode(frames[2]); !errors.Is(err, ReplayedFrame) {
+		t.Errorf("replay got %v", err)
+	}
+	tampered := append([]byte(nil), frames[0]...)
+	tampered[len(tampered)-1] ^= 1
+	if _, err := factory("conn2").Decode(tampered); !errors.Is(err, FrameAuthenticationFailed) {
+		t.Errorf("tampered got %v", err)
+	}
+	if err := client.(*AEADCodec).Rotate(9); err == nil {
+		t.Error("rotating to an unknown key should fail")
+	}
+}
+
+func TestReplayWindow(t *testing.T) {
+	var w replayWindow
+	for _, c := range []struct {
+		sequence uint64
+		ok       bool
+	}{
+		{5, true}, {5, false}, {3, true},
+		// 窗口是 37 到 100
+		{100, true}, {40, true}, {40, false}, {37, true}, {36, false}, {99, true}, {101, true},
+	} {
+		ok := w.check(c.sequence)
+		if ok != c.ok {
+			t.Errorf("sequence %d: got %v, want %v", c.sequence, ok, c.ok)
+		}
+		if ok {
+			w.accept(c.sequence)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+// Package bench 是 TCP 服务的压力测试工具，cmd/tcpbench 是它的命令行版本。
+//
+// 每个连接发送一个帧，等到收到一个响应帧之后再发送下一个，记录往返时延。
+// 被测服务可以是任何地址，也可以用 tcptest.Listener.DialContext 压测同一个进程里的 tcp.Server 。
+package bench
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/seedjyh/go-tcp/pkg/tcp"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Generator 返回第 connection 个连接要发送的第 n 个帧的内容，都从0开始。会被多个连接并发调用。
+type Generator func(connection, n int) []byte
+
+// Fixed 返回总是发送 payload 的 Generator 。
+func Fixed(payload []byte) Generator {
+	return func(connection, n int) []byte {
+		return payload
+	}
+}
+
+// Config 是一次压测的配置。
+type Config struct {
+	Address     string                                                               // 被测服务的地址
+	Dial        func(ctx context.Context, network, address string) (net.Conn, error) // 建立连接的函数。nil 表示 net.Dialer.DialContext
+	Connections int                                                                  // 同时保持的连接数
+	Duration    time.Duration                                                        // 最长的运行时间。0表示不限制，此时 Requests 不能是0
+	Requests    int                                                                  // 每个连接发送的帧数。0表示不限制，直到 Duration
+	Timeout     time.Duration                                                        // 建立连接和等待每个响应的时限。0表示 DefaultTimeout
+	Generator   Generator                                                            // 产生要发送的帧的内容
+	Framer      tcp.FramerFunc                                                       // 给帧的内容加上帧头。nil 表示内容就是完整的帧
+	Splitter    tcp.SplitterFunc                                                     // 拆分响应。nil 表示 tcp.DefaultSplitter ，收到的任何字节都算作一个响应
+}
+
+// DefaultTimeout 是 Config.Timeout 的默认值。
+const DefaultTimeout = 5 * time.Second
+
+// Result 是压测的结果。
+type Result struct {
+	Connections int
+	Duration    time.Duration // 实际的运行时间
+	Requests    int           // 收到响应的帧数
+	BytesSent   int64
+	BytesRecv   int64
+	Latencies   []time.Duration // 每个帧的往返时延，从小到大排列
+	Errors      map[string]int  // 按阶段和 tcp.DisconnectReason 归类的错误数，例如 "dial: network_error" 、 "read: timeout"
+}
+
+// Throughput 返回每秒收到响应的帧数。
+func (r *Result) Throughput() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return float64(r.Requests) / r.Duration.Seconds()
+}
+
+// Percentile 返回往返时延的百分位数，p 在0到100之间。
+func (r *Result) Percentile(p float64) time.Duration {
+	if len(r.Latencies) == 0 {
+		return 0
+	}
+	i := int(float64(len(r.Latencies)-1) * p / 100)
+	return r.Latencies[i]
+}
+
+func (r *Result) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "connections: %d, duration: %v\n", r.Connections, r.Duration.Round(time.Millisecond))
+	fmt.Fprintf(&b, "requests: %d (%.1f/s), sent %d bytes, received %d bytes\n", r.Requests, r.Throughput(), r.BytesSent, r.BytesRecv)
+	fmt.Fprintf(&b, "latency: p50 %v, p90 %v, p99 %v, max %v\n", r.Percentile(50), r.Percentile(90), r.Percentile(99), r.Percentile(100))
+	var kinds []string
+	for kind := range r.Errors {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		fmt.Fprintf(&b, "errors: %s %d\n", kind, r.Errors[kind])
+	}
+	return b.String()
+}
+
+// Run 运行压测，直到所有连接发送完 Requests 个帧、经过 Duration 或者 ctx 被取消。
+// 连接出错时记录错误，然后重新建立连接继续发送。
+func Run(ctx context.Context, config Config) (*Result, error) {
+	if config.Connections <= 0 {
+		return nil, errors.New("bench: connections must be positive")
+	}
+	if config.Duration <= 0 && config.Requests <= 0 {
+		return nil, errors.New("bench: either duration or requests must be set")
+	}
+	if config.Generator == nil {
+		return nil, errors.New("bench: generator is required")
+	}
+	if config.Dial == nil {
+		config.Dial = (&net.Dialer{}).DialContext
+	}
+	if config.Splitter == nil {
+		config.Splitter = tcp.DefaultSplitter
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultTimeout
+	}
+	if config.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.Duration)
+		defer cancel()
+	}
+	start := time.Now()
+	workers := make([]*worker, config.Connections)
+	var wg sync.WaitGroup
+	for i := range workers {
+		workers[i] = &worker{config: &config, index: i, errors: make(map[string]int)}
+		wg.Add(1)
+		go func(w *worker) {
+			defer wg.Done()
+			w.run(ctx)
+		}(workers[i])
+	}
+	wg.Wait()
+	result := &Result{Connections: config.Connections, Duration: time.Since(start), Errors: make(map[string]int)}
+	for _, w := range workers {
+		result.Requests += len(w.latencies)
+		result.BytesSent += w.sent
+		result.BytesRecv += w.received
+		result.Latencies = append(result.Latencies, w.latencies...)
+		for kind, n := range w.errors {
+			result.Errors[kind] += n
+		}
+	}
+	sort.Slice(result.Latencies, func(i, j int) bool { return result.Latencies[i] < result.Latencies[j] })
+	return result, nil
+}
+
+// worker 维护一个连接。只在自己的协程里使用，Run 在所有 worker 结束之后才读取结果。
+type worker struct {
+	config    *Config
+	index     int
+	sent      int64
+	received  int64
+	latencies []time.Duration
+	errors    map[string]int
+}
+
+func (w *worker) run(ctx context.Context) {
+	n := 0
+	for ctx.Err() == nil && (w.config.Requests <= 0 || n < w.config.Requests) {
+		dialCtx, cancel := context.WithTimeout(ctx, w.config.Timeout)
+		conn, err := w.config.Dial(dialCtx, "tcp", w.config.Address)
+		cancel()
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			w.fail("dial", err)
+			// 服务不可用时避免空转
+			select {
+			case <-ctx.Done():
+			case <-time.After(100 * time.Millisecond):
+			}
+			continue
+		}
+		n = w.keepSending(ctx, conn, n)
+		_ = conn.Close()
+	}
+}
+
+// keepSending 在 conn 上从第 n 个帧开始发送，返回下一个要发送的帧的序号。出错时返回。
+func (w *worker) keepSending(ctx context.Context, conn net.Conn, n int) int {
+	// ctx 结束时让阻塞的读写立刻返回
+	stop := context.AfterFunc(ctx, func() { _ = conn.SetDeadline(time.Now()) })
+	defer stop()
+	var buf []byte
+	readBuf := make([]byte, 4096)
+	for ; w.config.Requests <= 0 || n < w.config.Requests; n++ {
+		frame := w.config.Generator(w.index, n)
+		if w.config.Framer != nil {
+			frame = w.config.Framer(frame)
+		}
+		start := time.Now()
+		if err := conn.SetDeadline(start.Add(w.config.Timeout)); err != nil {
+			w.fail("write", err)
+			return n
+		} else if ctx.Err() != nil {
+			// 上面的 SetDeadline 可能覆盖了 AfterFunc 设置的截止时间
+			return n
+		}
+		if written, err := conn.Write(frame); err != nil {
+			w.sent += int64(written)
+			if ctx.Err() == nil {
+				w.fail("write", err)
+			}
+			return n
+		} else {
+			w.sent += int64(written)
+		}
+		for {
+			if _, consumed, err := w.config.Splitter(buf); err == nil {
+				buf = buf[consumed:]
+				break
+			} else if !errors.Is(err, tcp.NoEnoughData) {
+				w.fail("split", err)
+				return n + 1
+			}
+			if read, err := conn.Read(readBuf); err != nil {
+				if ctx.Err() == nil {
+					w.fail("read", err)
+				}
+				return n + 1
+			} else {
+				w.received += int64(read)
+				buf = append(buf, readBuf[:read]...)
+			}
+		}
+		w.latencies = append(w.latencies, time.Since(start))
+	}
+	return n
+}
+
+func (w *worker) fail(stage string, err error) {
+	w.errors[stage+": "+tcp.DisconnectReason(err)]++
+}
@@ -0,0 +1,67 @@
+package bench
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/seedjyh/go-tcp/pkg/tcp"
+	"github.com/seedjyh/go-tcp/pkg/tcp/tcptest"
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+)
+
+func newEchoServer() *tcp.Server {
+	s := tcp.NewServer()
+	s.SetLogHandler(slog.NewTextHandler(io.Discard, nil))
+	s.SetSplitter(tcp.LengthPrefixSplitter(1024))
+	s.SetFramer(tcp.LengthPrefixFramer)
+	s.SetDefaultHandler(func(c tcp.Context) error {
+		c.Send(tcp.NewPacket(c.Received().(*tcp.Packet).Bytes()))
+		return nil
+	})
+	return s
+}
+
+func TestRun(t *testing.T) {
+	ts := tcptest.NewServer(newEchoServer())
+	defer ts.Close()
+	result, err := Run(context.Background(), Config{
+		Dial:        ts.Listener.DialContext,
+		Connections: 4,
+		Requests:    50,
+		Generator: func(connection, n int) []byte {
+			return []byte(fmt.Sprintf("%d-%d", connection, n))
+		},
+		Framer:   tcp.LengthPrefixFramer,
+		Splitter: tcp.LengthPrefixSplitter(1024),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Requests != 200 || len(result.Errors) != 0 || result.BytesSent != result.BytesRecv {
+		t.Errorf("got %s", result)
+	}
+	if result.Percentile(50) > result.Percentile(99) || result.Percentile(100) != result.Latencies[199] {
+		t.Errorf("bad percentiles %s", result)
+	}
+}
+
+func TestRun_errors(t *testing.T) {
+	result, err := Run(context.Background(), Config{
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			return nil, errors.New("refused")
+		},
+		Connections: 2,
+		Duration:    150 * time.Millisecond,
+		Generator:   Fixed([]byte("x")),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Requests != 0 || result.Errors["dial: error"] < 2 {
+		t.Errorf("got %s", result)
+	}
+}
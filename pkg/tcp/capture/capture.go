@@ -0,0 +1,102 @@
+// Package capture 把 tcp.Recorder 记录的帧保存到文件，并且可以把保存的会话回放到服务上，比较响应。
+//
+// 文件是 JSON Lines 格式，每行一个帧：
+//
+//	{"time":"2026-10-19T10:00:00.123456789+08:00","connection":"0a1b...","direction":"in","data":"aGVsbG8K"}
+//
+// time 是 RFC 3339 格式的记录时间，connection 是连接 ID ，direction 是 in （收到的帧）或 out （发送的帧），
+// data 是 base64 编码的完整的帧，见 tcp.Recorder 。
+package capture
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"github.com/seedjyh/go-tcp/pkg/tcp"
+	"io"
+	"sync"
+	"time"
+)
+
+// Record 是文件里的一行。
+type Record struct {
+	Time         time.Time        `json:"time"`
+	ConnectionID tcp.ConnectionID `json:"connection"`
+	Direction    tcp.Direction    `json:"direction"`
+	Data         []byte           `json:"data"`
+}
+
+// Writer 是把帧写成 JSON Lines 的 tcp.Recorder ：
+//
+//	f, _ := os.Create("capture.jsonl")
+//	w := capture.NewWriter(f)
+//	s.SetRecorder(w)
+type Writer struct {
+	mutex   sync.Mutex
+	encoder *json.Encoder
+	err     error
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{encoder: json.NewEncoder(w)}
+}
+
+// Record 写入一行。写入出错后不再写入，错误可以用 Err 得到。
+func (w *Writer) Record(connectionID tcp.ConnectionID, direction tcp.Direction, frame []byte) {
+	record := Record{Time: time.Now(), ConnectionID: connectionID, Direction: direction, Data: frame}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.err == nil {
+		w.err = w.encoder.Encode(&record)
+	}
+}
+
+// Err 返回第一次写入的错误。
+func (w *Writer) Err() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.err
+}
+
+// Read 读取 r 里所有的行。
+func Read(r io.Reader) ([]Record, error) {
+	var records []Record
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 64<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, errors.Wrapf(err, "line %d", line)
+		}
+		if record.Direction != tcp.Inbound && record.Direction != tcp.Outbound {
+			return nil, errors.Errorf("line %d: unknown direction %q", line, record.Direction)
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
+
+// Session 是一个连接的所有帧，按记录的顺序。
+type Session struct {
+	ConnectionID tcp.ConnectionID
+	Records      []Record
+}
+
+// Sessions 按连接 ID 分组，按每个连接第一个帧的顺序排列。
+func Sessions(records []Record) []*Session {
+	var sessions []*Session
+	index := make(map[tcp.ConnectionID]*Session)
+	for _, record := range records {
+		session, ok := index[record.ConnectionID]
+		if !ok {
+			session = &Session{ConnectionID: record.ConnectionID}
+			index[record.ConnectionID] = session
+			sessions = append(sessions, session)
+		}
+		session.Records = append(session.Records, record)
+	}
+	return sessions
+}
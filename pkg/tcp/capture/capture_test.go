@@ -0,0 +1,113 @@
+package capture
+
+import (
+	"bytes"
+	"github.com/seedjyh/go-tcp/pkg/tcp"
+	"github.com/seedjyh/go-tcp/pkg/tcp/tcptest"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func lineSplitter(buf []byte) (*tcp.Packet, int, error) {
+	if i := bytes.IndexByte(buf, '\n'); i >= 0 {
+		return tcp.NewPacket(buf[:i]), i + 1, nil
+	}
+	return nil, 0, tcp.NoEnoughData
+}
+
+// newServer 返回一个服务：连接建立时不发送，每收到一行回复 transform 之后的行，收到 "twice" 时回复两行。
+func newServer(transform func(string) string) *tcp.Server {
+	s := tcp.NewServer()
+	s.SetLogHandler(slog.NewTextHandler(io.Discard, nil))
+	s.SetSplitter(lineSplitter)
+	s.SetDefaultHandler(func(c tcp.Context) error {
+		line := transform(string(c.Received().(*tcp.Packet).Bytes()))
+		c.Send(tcp.NewPacket([]byte(line + "\n")))
+		if line == transform("twice") {
+			c.Send(tcp.NewPacket([]byte(line + "\n")))
+		}
+		return nil
+	})
+	return s
+}
+
+func record(t *testing.T) []Record {
+	buf := bytes.NewBuffer(nil)
+	w := NewWriter(buf)
+	s := newServer(func(s string) string { return s })
+	s.SetRecorder(w)
+	ts := tcptest.NewServer(s)
+	for _, lines := range [][]string{{"hello", "twice", "bye"}, {"other"}} {
+		client, err := ts.Dial()
+		if err != nil {
+			t.Fatal(err)
+		}
+		client.SetSplitter(lineSplitter)
+		for _, line := range lines {
+			if err := client.Write([]byte(line + "\n")); err != nil {
+				t.Fatal(err)
+			}
+			// 收到所有回复之后再继续，保证关闭连接之前所有回复都已经记录
+			replies := 1
+			if line == "twice" {
+				replies = 2
+			}
+			for i := 0; i < replies; i++ {
+				if _, err := client.NextFrame(); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		_ = client.Close()
+		if _, err := client.WaitClosed(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ts.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Err(); err != nil {
+		t.Fatal(err)
+	}
+	records, err := Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+func replayServer(s *tcp.Server, sessions []*Session, timeout time.Duration) ([]*Mismatch, error) {
+	ts := tcptest.NewServer(s)
+	defer ts.Close()
+	return Replay(ts.Listener.Dial, sessions, timeout)
+}
+
+func TestReplay(t *testing.T) {
+	records := record(t)
+	sessions := Sessions(records)
+	if len(sessions) != 2 || len(sessions[0].Records) != 7 || len(sessions[1].Records) != 2 {
+		t.Fatalf("got %d records in %d sessions", len(records), len(sessions))
+	}
+	if r := sessions[0].Records[0]; r.Direction != tcp.Inbound || string(r.Data) != "hello\n" {
+		t.Errorf("first record %+v", r)
+	}
+	if mismatches, err := replayServer(newServer(func(s string) string { return s }), sessions, time.Second); err != nil || len(mismatches) != 0 {
+		t.Errorf("same server: %v, %v", mismatches, err)
+	}
+	mismatches, err := replayServer(newServer(strings.ToUpper), sessions, 50*time.Millisecond)
+	if err != nil || len(mismatches) != 2 {
+		t.Fatalf("changed server: %v, %v", mismatches, err)
+	}
+	if m := mismatches[0]; m.Sent != 1 || string(m.Want) != "hello\n" || string(m.Got) != "HELLO\n" {
+		t.Errorf("got %s", m)
+	}
+}
+
+func TestRead(t *testing.T) {
+	if _, err := Read(strings.NewReader(`{"direction":"sideways"}`)); err == nil {
+		t.Error("unknown direction is accepted")
+	}
+}
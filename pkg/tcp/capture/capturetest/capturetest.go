@@ -0,0 +1,17 @@
+// Package capturetest 在内存里回放抓包记录，用于测试。
+// 它依赖 tcptest ，所以和 capture 分开，只记录帧的程序不会引入测试用的包。
+package capturetest
+
+import (
+	"github.com/seedjyh/go-tcp/pkg/tcp"
+	"github.com/seedjyh/go-tcp/pkg/tcp/capture"
+	"github.com/seedjyh/go-tcp/pkg/tcp/tcptest"
+	"time"
+)
+
+// ReplayServer 和 capture.Replay 一样，但是在内存里回放到 s ，见 tcptest.NewServer 。s 应该已经配置好，但是还没有开始服务。
+func ReplayServer(s *tcp.Server, sessions []*capture.Session, timeout time.Duration) ([]*capture.Mismatch, error) {
+	ts := tcptest.NewServer(s)
+	defer ts.Close()
+	return capture.Replay(ts.Listener.Dial, sessions, timeout)
+}
@@ -0,0 +1,33 @@
+package capturetest
+
+import (
+	"github.com/seedjyh/go-tcp/pkg/tcp"
+	"github.com/seedjyh/go-tcp/pkg/tcp/capture"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func TestReplayServer(t *testing.T) {
+	s := tcp.NewServer()
+	s.SetLogHandler(slog.NewTextHandler(io.Discard, nil))
+	s.SetDefaultHandler(func(c tcp.Context) error {
+		c.Send(tcp.NewPacket(append([]byte("re:"), c.Received().(*tcp.Packet).Bytes()...)))
+		return nil
+	})
+	sessions := []*capture.Session{{
+		ConnectionID: "c1",
+		Records: []capture.Record{
+			{Direction: tcp.Inbound, Data: []byte("ping")},
+			{Direction: tcp.Outbound, Data: []byte("re:pong")},
+		},
+	}}
+	mismatches, err := ReplayServer(s, sessions, 100*time.Millisecond)
+	if err != nil || len(mismatches) != 1 {
+		t.Fatalf("got %v, %v", mismatches, err)
+	}
+	if m := mismatches[0]; m.Sent != 1 || string(m.Got) != "re:ping" {
+		t.Errorf("got %s", m)
+	}
+}
@@ -0,0 +1,92 @@
+package capture
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/seedjyh/go-tcp/pkg/tcp"
+	"io"
+	"net"
+	"time"
+)
+
+// Mismatch 是回放时和记录不同的响应。
+type Mismatch struct {
+	ConnectionID tcp.ConnectionID // 记录里的连接 ID
+	Sent         int              // 出现差异之前发送了几个收到的帧
+	Request      []byte           // 最后发送的帧，还没有发送时是 nil
+	Want         []byte           // 记录里 Request 之后发送的所有帧
+	Got          []byte           // 回放时收到的字节
+	Err          error            // 回放时读写的错误，例如超时
+}
+
+func (m *Mismatch) String() string {
+	s := fmt.Sprintf("connection %s, after %d frames (last %q): want %q, got %q", m.ConnectionID, m.Sent, m.Request, m.Want, m.Got)
+	if m.Err != nil {
+		s += fmt.Sprintf(": %v", m.Err)
+	}
+	return s
+}
+
+// Replay 把每个会话里收到的帧依次发送到 dial 得到的新连接，并比较响应和记录里发送的帧。
+// 每个会话最多返回一个 Mismatch ，因为出现差异之后的比较已经没有意义。
+//
+// 每发送一个帧之前，先读取记录里在它之前发送的所有帧的字节数，最多等待 timeout 。
+// 所以只有按顺序处理（见 tcp.Sequential ）、响应只取决于收到的帧的服务才能回放；
+// 响应里有时间、随机数或者连接 ID 的，或者使用了带随机 nonce 的 FrameCodec 的，会出现差异。
+// 不按记录的时间间隔发送。最后一个记录的帧之后多出来的响应不会被发现。
+// 在内存里回放到同一个进程里的服务，见 capturetest.ReplayServer 。
+func Replay(dial func() (net.Conn, error), sessions []*Session, timeout time.Duration) ([]*Mismatch, error) {
+	var mismatches []*Mismatch
+	for _, session := range sessions {
+		if conn, err := dial(); err != nil {
+			return mismatches, err
+		} else if m := replay(conn, session, timeout); m != nil {
+			mismatches = append(mismatches, m)
+		}
+	}
+	return mismatches, nil
+}
+
+// replay 回放一个会话，返回第一个差异。会关闭 conn 。
+func replay(conn net.Conn, session *Session, timeout time.Duration) *Mismatch {
+	defer conn.Close()
+	m := &Mismatch{ConnectionID: session.ConnectionID}
+	// expect 读取记录里已经发送的字节，比较之后清空 m.Want 。
+	expect := func() bool {
+		if len(m.Want) == 0 {
+			return true
+		}
+		m.Got = make([]byte, len(m.Want))
+		if m.Err = conn.SetReadDeadline(time.Now().Add(timeout)); m.Err != nil {
+			return false
+		}
+		n, err := io.ReadFull(conn, m.Got)
+		m.Got, m.Err = m.Got[:n], err
+		if err != nil || !bytes.Equal(m.Got, m.Want) {
+			return false
+		}
+		m.Want, m.Got = nil, nil
+		return true
+	}
+	for _, record := range session.Records {
+		if record.Direction == tcp.Outbound {
+			m.Want = append(m.Want, record.Data...)
+			continue
+		}
+		if !expect() {
+			return m
+		}
+		if m.Err = conn.SetWriteDeadline(time.Now().Add(timeout)); m.Err != nil {
+			return m
+		}
+		if _, m.Err = conn.Write(record.Data); m.Err != nil {
+			return m
+		}
+		m.Sent++
+		m.Request = record.Data
+	}
+	if !expect() {
+		return m
+	}
+	return nil
+}
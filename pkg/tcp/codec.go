@@ -0,0 +1,124 @@
+package tcp
+
+import (
+	"github.com/pkg/errors"
+	"sync"
+)
+
+// FrameCodec 变换一帧的内容，例如压缩或加密。
+// 收到的帧先由 SplitterFunc 拆分出内容，再 Decode ，然后交给中间件；
+// 要发送的消息先 Encode ，再由 FramerFunc 加上帧头。
+type FrameCodec interface {
+	Encode(payload []byte) ([]byte, error)
+	Decode(payload []byte) ([]byte, error)
+}
+
+// CodecFactory 为每个连接创建一个 FrameCodec 。有状态的 FrameCodec （例如记录序号的加密）每个连接需要单独的实例，
+// 无状态的可以每次返回同一个实例。同一个连接的 Encode 和 Decode 会在不同的协程里调用。
+type CodecFactory func(connectionID ConnectionID) FrameCodec
+
+// Codec 是用 Server.AddCodec 注册的 FrameCodec 。
+type Codec struct {
+	Name    string
+	Factory CodecFactory
+	Enabled bool // 新连接是否一开始就启用。否则要在协商之后用 Context.SetCodecEnabled 启用
+}
+
+// codecChain 是一个连接的所有 FrameCodec 和它们的启用状态。
+// Encode 按注册顺序执行，Decode 按相反的顺序执行，例如先压缩再加密，先解密再解压。
+// 收和发的启用状态分开记录：收取方向由 Processor 在处理函数返回后修改，发送方向由 Sender 按队列里的顺序修改。
+type codecChain struct {
+	names    []string
+	codecs   []FrameCodec
+	mutex    sync.Mutex
+	inbound  []bool
+	outbound []bool       // 只由 Sender 访问
+	pending  map[int]bool // 处理函数要求的收取方向的变化，处理函数返回后生效
+}
+
+func newCodecChain(connectionID ConnectionID, codecs []Codec) *codecChain {
+	c := &codecChain{
+		names:    make([]string, len(codecs)),
+		codecs:   make([]FrameCodec, len(codecs)),
+		inbound:  make([]bool, len(codecs)),
+		outbound: make([]bool, len(codecs)),
+		pending:  make(map[int]bool),
+	}
+	for i, codec := range codecs {
+		c.names[i] = codec.Name
+		c.codecs[i] = codec.Factory(connectionID)
+		c.inbound[i] = codec.Enabled
+		c.outbound[i] = codec.Enabled
+	}
+	return c
+}
+
+func (c *codecChain) index(name string) int {
+	for i, n := range c.names {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// decode 用启用的 FrameCodec 按相反的顺序解码。
+func (c *codecChain) decode(payload []byte) ([]byte, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for i := len(c.codecs) - 1; i >= 0; i-- {
+		if !c.inbound[i] {
+			continue
+		}
+		if decoded, err := c.codecs[i].Decode(payload); err != nil {
+			return nil, errors.WithMessagef(err, "decode %s", c.names[i])
+		} else {
+			payload = decoded
+		}
+	}
+	return payload, nil
+}
+
+// encode 用启用的 FrameCodec 按注册顺序编码。只由 Sender 调用。
+func (c *codecChain) encode(payload []byte) ([]byte, error) {
+	for i, codec := range c.codecs {
+		if !c.outbound[i] {
+			continue
+		}
+		if encoded, err := codec.Encode(payload); err != nil {
+			return nil, errors.WithMessagef(err, "encode %s", c.names[i])
+		} else {
+			payload = encoded
+		}
+	}
+	return payload, nil
+}
+
+// setInbound 要求处理函数返回后修改收取方向的启用状态。
+func (c *codecChain) setInbound(i int, enabled bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.pending[i] = enabled
+}
+
+// applyPending 让 setInbound 的修改生效。
+func (c *codecChain) applyPending() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for i, enabled := range c.pending {
+		c.inbound[i] = enabled
+	}
+	clear(c.pending)
+}
+
+// codecChange 是放入待发送消息队列的控制消息。Sender 收到它时修改发送方向的启用状态，
+// 于是在它之前放入队列的消息用原来的状态发送，之后的用新的状态发送。
+type codecChange struct {
+	index   int
+	enabled bool
+}
+
+// Bytes 让 codecChange 可以放入待发送消息队列。它不会被发送。
+func (c *codecChange) Bytes() []byte {
+	return nil
+}
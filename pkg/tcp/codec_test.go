@@ -0,0 +1,123 @@
+package tcp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// enqueueCounter 记录 SendQueueDepth 被调用的次数，即放入待发送消息队列的消息数。
+type enqueueCounter struct {
+	NopMetricsCollector
+	enqueued atomic.Int64
+}
+
+func (c *enqueueCounter) SendQueueDepth(int) {
+	c.enqueued.Add(1)
+}
+
+func TestCompressionCodec(t *testing.T) {
+	large := bytes.Repeat([]byte("telemetry "), 100)
+	for name, factory := range map[string]CodecFactory{
+		"deflate": Deflate(CompressionConfig{Threshold: 64, MaxDecompressedSize: 2000}),
+		"gzip":    Gzip(CompressionConfig{Threshold: 64, MaxDecompressedSize: 2000}),
+	} {
+		codec := factory("conn1")
+		for _, payload := range [][]byte{[]byte("small"), large} {
+			encoded, err := codec.Encode(payload)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if compressed := encoded[0] == compressionFlagCompressed; compressed != (len(payload) >= 64) {
+				t.Errorf("%s: %d bytes compressed=%v", name, len(payload), compressed)
+			}
+			if decoded, err := codec.Decode(encoded); err != nil || !bytes.Equal(decoded, payload) {
+				t.Errorf("%s: round trip got %q, %v", name, decoded, err)
+			}
+		}
+		if len(mustEncode(t, codec, large)) >= len(large)/2 {
+			t.Errorf("%s: not compressed", name)
+		}
+		bomb := mustEncode(t, codec, bytes.Repeat([]byte{0}, 3000))
+		if _, err := codec.Decode(bomb); !errors.Is(err, BadMessageFormat) {
+			t.Errorf("%s: decompressing over the limit got %v", name, err)
+		}
+	}
+}
+
+func mustEncode(t *testing.T, codec FrameCodec, payload []byte) []byte {
+	encoded, err := codec.Encode(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encoded
+}
+
+func TestContext_SetCodecEnabled(t *testing.T) {
+	codec := Deflate(CompressionConfig{})("")
+	metrics := &enqueueCounter{}
+	s := NewServer()
+	s.SetLogHandler(slog.NewTextHandler(io.Discard, nil))
+	s.SetMetricsCollector(metrics)
+	s.SetSplitter(LengthPrefixSplitter(1024))
+	s.SetFramer(LengthPrefixFramer)
+	s.AddCodec("deflate", Deflate(CompressionConfig{}), false)
+	s.SetDefaultHandler(func(c Context) error {
+		received := c.Received().(*Packet).Bytes()
+		c.Send(NewPacket(append([]byte("echo:"), received...)))
+		if string(received) == "COMPRESS" {
+			return c.SetCodecEnabled("deflate", true)
+		}
+		return nil
+	})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() { done <- s.Serve(ln) }()
+	defer func() {
+		_ = s.Stop()
+		<-done
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(time.Second))
+	split := LengthPrefixSplitter(1024)
+	readFrame := func() []byte {
+		buf := make([]byte, 0, 1024)
+		for {
+			if p, _, err := split(buf); err == nil {
+				return p.Bytes()
+			}
+			b := make([]byte, 1024)
+			n, err := conn.Read(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			buf = append(buf, b[:n]...)
+		}
+	}
+	// 协商之前收发都不压缩，协商的响应也不压缩
+	_, _ = conn.Write(LengthPrefixFramer([]byte("COMPRESS")))
+	if got := string(readFrame()); got != "echo:COMPRESS" {
+		t.Errorf("negotiation reply %q", got)
+	}
+	_, _ = conn.Write(LengthPrefixFramer(mustEncode(t, codec, []byte("hello"))))
+	if decoded, err := codec.Decode(readFrame()); err != nil || string(decoded) != "echo:hello" {
+		t.Errorf("compressed reply %q, %v", decoded, err)
+	}
+	// 启用 FrameCodec 的控制消息不计入发送队列长度
+	if n := metrics.enqueued.Load(); n != 2 {
+		t.Errorf("%d messages enqueued, want 2", n)
+	}
+}
@@ -0,0 +1,132 @@
+package tcp
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"github.com/pkg/errors"
+	"io"
+	"sync"
+)
+
+// DefaultMaxDecompressedSize 是 CompressionConfig.MaxDecompressedSize 的默认值。
+const DefaultMaxDecompressedSize = 4 << 20
+
+const (
+	compressionFlagRaw        byte = 0 // 帧内容没有压缩
+	compressionFlagCompressed byte = 1 // 帧内容是压缩过的
+)
+
+// CompressionConfig 是压缩编解码的配置。
+type CompressionConfig struct {
+	Level               int // 压缩级别，见 compress/flate 。0表示 flate.DefaultCompression
+	Threshold           int // 帧内容小于这个字节数时不压缩，避免压缩小帧反而变大
+	MaxDecompressedSize int // 解压后的最大字节数，防止压缩炸弹。0表示 DefaultMaxDecompressedSize
+}
+
+// Deflate 返回用 compress/flate 压缩帧内容的 CodecFactory 。
+// 编码后的帧内容第一个字节表示是否压缩：0表示原样，1表示压缩过。
+func Deflate(config CompressionConfig) CodecFactory {
+	codec := newCompressionCodec(config,
+		func(w io.Writer, level int) (compressor, error) { return flate.NewWriter(w, level) },
+		func(r io.Reader) (io.ReadCloser, error) { return flate.NewReader(r), nil },
+	)
+	return func(ConnectionID) FrameCodec { return codec }
+}
+
+// Gzip 和 Deflate 一样，但是使用 compress/gzip 的格式，多了头部和校验和。
+func Gzip(config CompressionConfig) CodecFactory {
+	codec := newCompressionCodec(config,
+		func(w io.Writer, level int) (compressor, error) { return gzip.NewWriterLevel(w, level) },
+		func(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) },
+	)
+	return func(ConnectionID) FrameCodec { return codec }
+}
+
+// compressor 是 flate.Writer 和 gzip.Writer 的共同部分。
+type compressor interface {
+	io.WriteCloser
+	Reset(w io.Writer)
+}
+
+// compressionCodec 是无状态的，可以被所有连接共享。
+type compressionCodec struct {
+	config    CompressionConfig
+	writers   sync.Pool
+	newReader func(r io.Reader) (io.ReadCloser, error)
+	err       error // 配置错误，例如压缩级别不对。Encode 会返回它
+}
+
+func newCompressionCodec(
+	config CompressionConfig,
+	newWriter func(w io.Writer, level int) (compressor, error),
+	newReader func(r io.Reader) (io.ReadCloser, error),
+) *compressionCodec {
+	if config.Level == 0 {
+		config.Level = flate.DefaultCompression
+	}
+	if config.MaxDecompressedSize <= 0 {
+		config.MaxDecompressedSize = DefaultMaxDecompressedSize
+	}
+	c := &compressionCodec{
+		config:    config,
+		newReader: newReader,
+	}
+	if _, err := newWriter(io.Discard, config.Level); err != nil {
+		c.err = err
+		return c
+	}
+	c.writers.New = func() interface{} {
+		w, _ := newWriter(io.Discard, config.Level)
+		return w
+	}
+	return c
+}
+
+func (c *compressionCodec) Encode(payload []byte) ([]byte, error) {
+	if len(payload) < c.config.Threshold {
+		return append([]byte{compressionFlagRaw}, payload...), nil
+	}
+	if c.err != nil {
+		return nil, c.err
+	}
+	w := c.writers.Get().(compressor)
+	defer c.writers.Put(w)
+	buf := bytes.NewBuffer(make([]byte, 0, len(payload)/2+1))
+	buf.WriteByte(compressionFlagCompressed)
+	w.Reset(buf)
+	if _, err := w.Write(payload); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *compressionCodec) Decode(payload []byte) ([]byte, error) {
+	if len(payload) == 0 {
+		return nil, errors.Wrap(BadMessageFormat, "missing compression flag")
+	}
+	switch payload[0] {
+	case compressionFlagRaw:
+		return payload[1:], nil
+	case compressionFlagCompressed:
+	default:
+		return nil, errors.Wrapf(BadMessageFormat, "compression flag %d", payload[0])
+	}
+	r, err := c.newReader(bytes.NewReader(payload[1:]))
+	if err != nil {
+		return nil, errors.Wrap(BadMessageFormat, err.Error())
+	}
+	defer r.Close()
+	// 多读一个字节，用来发现超过上限的内容。
+	decoded, err := io.ReadAll(io.LimitReader(r, int64(c.config.MaxDecompressedSize)+1))
+	if err != nil {
+		return nil, errors.Wrap(BadMessageFormat, err.Error())
+	}
+	if len(decoded) > c.config.MaxDecompressedSize {
+		return nil, errors.Wrapf(BadMessageFormat, "decompressed size exceeds %d", c.config.MaxDecompressedSize)
+	}
+	return decoded, nil
+}
@@ -1,5 +1,14 @@
 package tcp
 
+import (
+	"context"
+	"crypto/tls"
+	"github.com/pkg/errors"
+	"log/slog"
+	"net"
+	"sync"
+)
+
 type ReceivedMessage interface{}
 type SendingMessage Serializable
 
@@ -7,21 +16,81 @@ type SendingMessage Serializable
 // 参考 https://github.com/labstack/echo
 type Context interface {
 	ConnectionID() ConnectionID
+	// RemoteAddr 返回连接的远端地址。
+	RemoteAddr() net.Addr
 	Received() ReceivedMessage
 	SetReceived(m ReceivedMessage)
+	// Send 把 m 放入待发送消息队列。连接已经关闭时 m 会被丢弃。
+	// PipelinedOrdered 模式下，处理函数返回前发送的消息会先缓存，等之前收到的消息的响应都发送后才放入队列。
 	Send(m SendingMessage)
+	// Error 把 err 交给 Server 的 ErrorHandlerFunc 处理。
+	Error(err error)
+	// Close 通知关闭连接。已经放入待发送消息队列的消息仍会尝试发送。
+	Close()
+	// Logger 返回带有连接 ID 和远端地址的 logger 。
+	Logger() *slog.Logger
+	// RouteName 返回匹配到的路由规则的名字（见 RouterPair.SetName ）。由默认处理函数处理时是 DefaultRouteName 。
+	RouteName() string
+	SetRouteName(name string)
+	// Context 返回处理这条消息用的 context.Context ，携带了 Tracer 创建的 Span 。
+	// 它在连接中断、Server 停止、处理时限（见 Server.SetHandlerTimeout ）到期或者处理函数返回时被取消，
+	// 所以处理函数应该把它传给数据库、HTTP 等下游调用。连接中断的原因可以用 context.Cause 得到。
+	Context() context.Context
+	SetContext(ctx context.Context)
+	// Authenticate 标记连接通过了认证， principal 是认证得到的身份。通常在握手处理函数里调用（见 Server.SetHandshake ）。
+	Authenticate(principal interface{})
+	// Principal 返回 Authenticate 记录的身份。没有认证过时返回 nil 。
+	Principal() interface{}
+	// Protocol 返回连接当前使用的协议的名字（见 Server.AddProtocol ）。
+	Protocol() string
+	// SwitchProtocol 要求处理函数返回后，连接之后收到的字节改用 name 协议的分包器和处理栈。
+	// 只能在 Sequential 模式下、在处理函数返回前调用。
+	SwitchProtocol(name string) error
+	// StartTLS 要求处理函数返回后，等已经发送的响应发完，在这个连接上用 config 完成 TLS 握手，之后的收发都经过加密。
+	// 连接 ID 不变。只能在 Sequential 模式下、开启 Server.SetStartTLSEnabled 后、在处理函数返回前调用。握手失败会关闭连接。
+	StartTLS(config *tls.Config) error
+	// TLS 返回处理这条消息时连接的 TLS 状态。不是 TLS 连接时返回 nil 。
+	TLS() *tls.ConnectionState
+	// SetCodecEnabled 启用或停用 Server.AddCodec 注册的 FrameCodec ，例如在协商压缩之后启用。
+	// 发送方向对之后调用 Send 发送的消息生效；收取方向在处理函数返回后生效。只能在 Sequential 模式下、在处理函数返回前调用。
+	SetCodecEnabled(name string, enabled bool) error
+	// Codec 返回这个连接的 FrameCodec 实例，例如用来调用 (*AEADCodec).Rotate 。没有这个名字时返回 nil 。
+	Codec(name string) FrameCodec
+	// SetValue 保存一个连接级别的值，同一连接之后的消息都能用 Value 读取。
+	// visible 为 true 时，Server.Connections 和管理接口（见 admin 子包）会显示它，所以不要把密钥之类的值设为 visible 。
+	SetValue(key string, value interface{}, visible bool)
+	// Value 返回 SetValue 保存的值。没有时返回 nil 。
+	Value(key string) interface{}
 }
 
 type handleContext struct {
 	connID                ConnectionID
+	remoteAddr            net.Addr
 	received              ReceivedMessage
 	sendingMessageChannel chan<- SendingMessage
+	stats                 *connectionStats // 连接的收发统计，Send 在这里记录队列深度
+	done                  <-chan struct{}  // 连接的生命周期
+	errorHandler          ErrorHandlerFunc
+	closeFunc             func()
+	logger                *slog.Logger
+	metrics               MetricsCollector
+	routeName             string
+	ctx                   context.Context
+	replies               *orderedReplies // PipelinedOrdered 模式下缓存响应
+	session               *session        // 连接的认证状态，同一连接的所有消息共享
+	protocols             *protocolSelector
+	tlsState              *tls.ConnectionState
+	codecs                *codecChain
 }
 
 func (c *handleContext) ConnectionID() ConnectionID {
 	return c.connID
 }
 
+func (c *handleContext) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
 func (c *handleContext) Received() ReceivedMessage {
 	return c.received
 }
@@ -31,5 +100,178 @@ func (c *handleContext) SetReceived(m ReceivedMessage) {
 }
 
 func (c *handleContext) Send(m SendingMessage) {
-	c.sendingMessageChannel <- m
+	if c.replies != nil && c.replies.add(m) {
+		return
+	}
+	c.metrics.SendQueueDepth(c.stats.enqueue())
+	select {
+	case c.sendingMessageChannel <- m:
+	case <-c.done:
+		c.stats.dequeue()
+		c.metrics.MessageDropped()
+	}
+}
+
+func (c *handleContext) Error(err error) {
+	c.errorHandler(c, err)
+}
+
+func (c *handleContext) Close() {
+	c.closeFunc()
+}
+
+func (c *handleContext) Logger() *slog.Logger {
+	return c.logger
+}
+
+func (c *handleContext) RouteName() string {
+	return c.routeName
+}
+
+func (c *handleContext) SetRouteName(name string) {
+	c.routeName = name
+}
+
+// connectionContextKey 是 Context.Context 里记录连接生命周期的键。
+type connectionContextKey struct{}
+
+// withConnectionContext 把连接的生命周期 ctx 记录在它自己里面，从它派生的消息的 context.Context 都能用 connectionContextOf 找到它。
+func withConnectionContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, connectionContextKey{}, ctx)
+}
+
+// connectionContextOf 返回 ctx 所属连接的生命周期。找不到时返回 nil 。
+func connectionContextOf(ctx context.Context) context.Context {
+	if connection, ok := ctx.Value(connectionContextKey{}).(context.Context); ok {
+		return connection
+	}
+	return nil
+}
+
+func (c *handleContext) Context() context.Context {
+	return c.ctx
+}
+
+func (c *handleContext) SetContext(ctx context.Context) {
+	c.ctx = ctx
+}
+
+func (c *handleContext) Authenticate(principal interface{}) {
+	if c.session != nil {
+		c.session.authenticate(principal)
+	}
+}
+
+func (c *handleContext) Principal() interface{} {
+	if c.session == nil {
+		return nil
+	}
+	return c.session.getPrincipal()
+}
+
+func (c *handleContext) SetValue(key string, value interface{}, visible bool) {
+	if c.session != nil {
+		c.session.setValue(key, value, visible)
+	}
+}
+
+func (c *handleContext) Value(key string) interface{} {
+	if c.session == nil {
+		return nil
+	}
+	return c.session.getValue(key)
+}
+
+func (c *handleContext) Protocol() string {
+	if c.protocols == nil {
+		return DefaultProtocolName
+	}
+	if p := c.protocols.selected(); p != nil {
+		return p.name
+	}
+	return c.protocols.fallback.name
+}
+
+func (c *handleContext) SwitchProtocol(name string) error {
+	if c.protocols == nil {
+		return errors.Wrap(ProtocolSwitchNotSupported, "no connection")
+	}
+	return c.protocols.switchTo(name)
+}
+
+func (c *handleContext) StartTLS(config *tls.Config) error {
+	if c.protocols == nil {
+		return errors.Wrap(StartTLSNotSupported, "no connection")
+	}
+	if c.tlsState != nil {
+		return errors.Wrap(StartTLSNotSupported, "already TLS")
+	}
+	return c.protocols.startTLS(config)
+}
+
+func (c *handleContext) TLS() *tls.ConnectionState {
+	return c.tlsState
+}
+
+func (c *handleContext) SetCodecEnabled(name string, enabled bool) error {
+	if c.codecs == nil || c.codecs.index(name) < 0 {
+		return errors.Wrap(UnknownCodec, name)
+	}
+	if c.protocols == nil || c.protocols.ready == nil {
+		return errors.Wrap(CodecChangeNotSupported, "not in Sequential mode")
+	}
+	i := c.codecs.index(name)
+	c.codecs.setInbound(i, enabled)
+	c.sendControl(&codecChange{index: i, enabled: enabled})
+	return nil
+}
+
+// sendControl 把给 Sender 的控制消息放入待发送消息队列。控制消息不会被发送，所以不计入发送队列长度等指标。
+func (c *handleContext) sendControl(m SendingMessage) {
+	select {
+	case c.sendingMessageChannel <- m:
+	case <-c.done:
+	}
+}
+
+func (c *handleContext) Codec(name string) FrameCodec {
+	if c.codecs == nil {
+		return nil
+	}
+	if i := c.codecs.index(name); i >= 0 {
+		return c.codecs.codecs[i]
+	}
+	return nil
+}
+
+// orderedReplies 缓存一条消息在处理函数返回前发送的响应。
+type orderedReplies struct {
+	mutex    sync.Mutex
+	messages []SendingMessage
+	finished bool
+	done     chan struct{} // finish 之后关闭
+}
+
+func newOrderedReplies() *orderedReplies {
+	return &orderedReplies{
+		done: make(chan struct{}),
+	}
+}
+
+// add 缓存 m 。处理函数已经返回时不缓存，返回 false 。
+func (r *orderedReplies) add(m SendingMessage) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.finished {
+		return false
+	}
+	r.messages = append(r.messages, m)
+	return true
+}
+
+func (r *orderedReplies) finish() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.finished = true
+	close(r.done)
 }
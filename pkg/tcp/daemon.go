@@ -3,49 +3,97 @@ package tcp
 import (
 	"context"
 	"golang.org/x/sync/errgroup"
+	"log/slog"
+	"time"
 )
 
+// DaemonConfig 是所有 Daemon 共享的配置。由 Server 在 Start 时生成。
+type DaemonConfig struct {
+	Splitter           SplitterFunc
+	Handler            HandlerFunc // 已经包裹了中间件和路由的处理函数
+	ErrorHandler       ErrorHandlerFunc
+	PanicPolicy        PanicPolicy
+	OnConnected        OnConnectedFunc
+	OnDisconnected     OnDisconnectedFunc
+	Logger             *slog.Logger
+	SlowWriteThreshold time.Duration // 一次发送超过这个时长会记录日志。0表示不记录。
+	Metrics            MetricsCollector
+	Tracer             Tracer
+	HandlerTimeout     time.Duration // 每条消息的处理时限，体现在 Context.Context 的截止时间上。0表示不限制。
+	ProcessingMode     ProcessingMode
+	Concurrency        int           // 非 Sequential 模式下，一个连接最多同时处理的消息数
+	Handshake          HandlerFunc   // 握手处理函数。nil 表示不需要握手
+	HandshakeTimeout   time.Duration // 连接建立后必须在这个时长内通过认证。0表示不限制。
+	Protocols          []*Protocol   // 用 Server.AddProtocol 注册的其他协议。Splitter 和 Handler 是默认协议的
+	Framer             FramerFunc    // 给要发送的消息加上帧头。nil 表示 SendingMessage.Bytes 就是完整的帧
+	Codecs             []Codec       // 对帧的内容做的变换
+	StartTLS           bool          // 是否允许 Context.StartTLS
+	Recorder           Recorder      // 记录收发的帧。nil 表示不记录
+}
+
 // Daemon 负责管理一个 net.Conn 的全生命周期。
 // 包括 sender 和 receiver 的协程生命周期，以及 net.Conn 的关闭。
 // 这里的设计理念是，不对外界直接提供消息发送接口。外界只有收到消息并被回调处理，才能得到发送接口。
 type Daemon struct {
-	connection     *Connection
-	splitter       SplitterFunc
-	handler        HandlerFunc
-	onConnected    OnConnectedFunc
-	onDisconnected OnDisconnectedFunc
+	connection *Connection
+	config     *DaemonConfig
+	logger     *slog.Logger // 带有连接 ID 和远端地址的 logger
 }
 
-func NewDaemon(connection *Connection, splitter SplitterFunc, handler HandlerFunc, onConnected OnConnectedFunc, onDisconnected OnDisconnectedFunc) *Daemon {
+func NewDaemon(connection *Connection, config *DaemonConfig) *Daemon {
 	return &Daemon{
-		connection:     connection,
-		splitter:       splitter,
-		handler:        handler,
-		onConnected:    onConnected,
-		onDisconnected: onDisconnected,
+		connection: connection,
+		config:     config,
+		logger:     connection.logger(config.Logger),
 	}
 }
 
-// KeepWorking 持续工作，直到出错时退出。
+// KeepWorking 持续工作，直到出错时退出。返回值是连接中断的原因。
 // 不会关闭任何外部传入的资源（如 net.Conn, inSiteMessageBuf, outSiteMessageBus 就不会关闭)
 func (d *Daemon) KeepWorking(ctx context.Context) error {
 	// 1. 创建两个channel
 	receivedMessageChannel := make(chan ReceivedMessage)
 	defer close(receivedMessageChannel)
+	// sendingMessageChannel 不关闭：处理函数可能在别的协程里异步调用 Context.Send ，
+	// 它们在连接中断后会通过 ctx 发现并丢弃消息。
 	sendingMessageChannel := make(chan SendingMessage)
-	defer close(sendingMessageChannel)
-	forwardingMessageChannel := d.onConnected(d.connection.connectionID)
-	defer d.onDisconnected(d.connection.connectionID)
+	forwardingMessageChannel := d.config.OnConnected(d.connection.connectionID)
+	defer d.config.OnDisconnected(d.connection.connectionID)
+	d.logger.Info("tcp: connected")
+	d.config.Metrics.ConnectionOpened()
 	// 2. 创建4个goroutine
-	ctx, cancel := context.WithCancel(ctx)
-	eg, ctx := errgroup.WithContext(ctx)
-	eg.Go(func() error { return NewReceiver(d.connection, d.splitter, receivedMessageChannel).KeepWorking(ctx) })
-	eg.Go(func() error { return NewSender(d.connection, sendingMessageChannel).KeepWorking(ctx) })
-	eg.Go(func() error { return NewForwarder(forwardingMessageChannel, sendingMessageChannel).KeepWorking(ctx) })
-	eg.Go(func() error {
-		return NewProcessor(d.connection.connectionID, receivedMessageChannel, sendingMessageChannel, d.handler).KeepWorking(ctx)
+	// 任何一个协程出错，都以这个错误作为原因取消 ctx ，于是 context.Cause(ctx) 就是连接中断的原因。
+	// Context.StartTLS 会替换 d.connection.conn ，这里保留原来的连接用于设置截止时间。
+	rawConn := d.connection.conn
+	ctx, cancel := context.WithCancelCause(ctx)
+	protocols := newProtocolSelector(d.config)
+	codecs := newCodecChain(d.connection.connectionID, d.config.Codecs)
+	var eg errgroup.Group
+	goUntilError := func(f func() error) {
+		eg.Go(func() error {
+			err := f()
+			cancel(err)
+			return err
+		})
+	}
+	goUntilError(func() error {
+		return newReceiver(d.connection, protocols, codecs, receivedMessageChannel, d.logger, d.config.Metrics, d.config.Recorder).KeepWorking(ctx)
+	})
+	goUntilError(func() error {
+		return newSender(d.connection, codecs, sendingMessageChannel, d.config.Framer, d.logger, d.config.SlowWriteThreshold, d.config.Metrics, d.config.Recorder).KeepWorking(ctx)
+	})
+	goUntilError(func() error {
+		return newForwarder(d.connection, forwardingMessageChannel, sendingMessageChannel, d.config.Metrics).KeepWorking(ctx)
+	})
+	goUntilError(func() error {
+		return newProcessor(d.connection, protocols, codecs, receivedMessageChannel, sendingMessageChannel, d.config, d.logger, cancel).KeepWorking(ctx)
 	})
 	<-ctx.Done()
-	cancel()
-	return eg.Wait()
+	reason := context.Cause(ctx)
+	// 让阻塞在 Read 的 Receiver 立刻返回。net.Conn 本身仍由外部关闭。
+	_ = rawConn.SetReadDeadline(time.Now())
+	_ = eg.Wait()
+	d.logger.Info("tcp: disconnected", errorAttr("reason", reason))
+	d.config.Metrics.ConnectionClosed(DisconnectReason(reason))
+	return reason
 }
@@ -6,5 +6,56 @@ var (
 	// NoEnoughData 当前数据不够
 	NoEnoughData = errors.New("no enough data")
 	// BadMessageFormat 数据格式错
-	BadMessageFormat = errors.New("no enough data")
+	BadMessageFormat = errors.New("bad message format")
+	// BadSplitter SplitterFunc 的返回值不对：没有错误但是 Packet 是 nil ，或者读取的长度不在 1 到 len(buf) 之间
+	BadSplitter = errors.New("bad splitter")
+	// UnknownMessage 没有任何处理函数能处理这个消息
+	UnknownMessage = errors.New("unknown message")
+	// CloseConnection 处理函数返回的错误包含它时，DefaultErrorHandler 会关闭连接。
+	CloseConnection = errors.New("close connection")
+	// ConnectionClosedByHandler 连接中断的原因：处理函数调用了 Context.Close
+	ConnectionClosedByHandler = errors.New("connection closed by handler")
+	// ConnectionDisconnected 连接中断的原因：调用了 Server.Disconnect
+	ConnectionDisconnected = errors.New("connection disconnected")
+	// ServerStopped 连接中断的原因：Server 停止了
+	ServerStopped = errors.New("server stopped")
+	// UnknownProtocol 没有这个名字的协议
+	UnknownProtocol = errors.New("unknown protocol")
+	// ProtocolSwitchNotSupported 当前的处理模式下不能切换协议
+	ProtocolSwitchNotSupported = errors.New("protocol switch not supported")
+	// StartTLSNotSupported 当前连接不能升级到 TLS
+	StartTLSNotSupported = errors.New("start tls not supported")
+	// UnknownCodec 没有这个名字的 FrameCodec
+	UnknownCodec = errors.New("unknown codec")
+	// CodecChangeNotSupported 当前的处理模式下不能启用或停用 FrameCodec
+	CodecChangeNotSupported = errors.New("codec change not supported")
+	// HandshakeFailed 连接中断的原因：握手处理函数返回了错误
+	HandshakeFailed = errors.New("handshake failed")
+	// HandshakeTimedOut 连接中断的原因：没有在握手时限内通过认证
+	HandshakeTimedOut = errors.New("handshake timed out")
 )
+
+// ReplyError 是处理函数返回的错误。DefaultErrorHandler 收到它时会把 Reply 发送到连接。
+// 可以用 errors.Wrap(NewReplyError(reply, CloseConnection), "...") 的方式同时要求发送错误响应和关闭连接。
+type ReplyError struct {
+	Reply SendingMessage // 要发送的错误响应
+	Err   error          // 原始错误
+}
+
+func NewReplyError(reply SendingMessage, err error) *ReplyError {
+	return &ReplyError{
+		Reply: reply,
+		Err:   err,
+	}
+}
+
+func (e *ReplyError) Error() string {
+	if e.Err == nil {
+		return "reply error"
+	}
+	return e.Err.Error()
+}
+
+func (e *ReplyError) Unwrap() error {
+	return e.Err
+}
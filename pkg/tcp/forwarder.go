@@ -6,14 +6,26 @@ import (
 )
 
 type Forwarder struct {
+	connection            *Connection
 	outSiteMessageBus     <-chan SendingMessage
 	sendingMessageChannel chan<- SendingMessage
+	metrics               MetricsCollector
 }
 
+// NewForwarder 创建一个把 outSiteMessageBus 里的消息转交给 sendingMessageChannel 的 Forwarder ，不记录发送队列长度。
+//
+// Deprecated: Forwarder 和同一个连接的 Sender 共享发送队列长度的计数，应该用 NewDaemon 创建。
 func NewForwarder(outSiteMessageBus <-chan SendingMessage, sendingMessageChannel chan<- SendingMessage) *Forwarder {
+	return newForwarder(&Connection{}, outSiteMessageBus, sendingMessageChannel, NopMetricsCollector{})
+}
+
+// newForwarder 由 Daemon 调用。
+func newForwarder(connection *Connection, outSiteMessageBus <-chan SendingMessage, sendingMessageChannel chan<- SendingMessage, metrics MetricsCollector) *Forwarder {
 	return &Forwarder{
+		connection:            connection,
 		outSiteMessageBus:     outSiteMessageBus,
 		sendingMessageChannel: sendingMessageChannel,
+		metrics:               metrics,
 	}
 }
 
@@ -26,7 +38,14 @@ func (f *Forwarder) KeepWorking(ctx context.Context) error {
 			if !ok {
 				return errors.New("channel is closed")
 			}
-			f.sendingMessageChannel <- m
+			f.metrics.SendQueueDepth(f.connection.stats.enqueue())
+			select {
+			case f.sendingMessageChannel <- m:
+			case <-ctx.Done():
+				f.connection.stats.dequeue()
+				f.metrics.MessageDropped()
+				return errors.New("context is done")
+			}
 		}
 	}
 }
@@ -0,0 +1,18 @@
+package tcp
+
+import (
+	"context"
+	"testing"
+)
+
+func TestNewForwarder(t *testing.T) {
+	out := make(chan SendingMessage, 1)
+	sending := make(chan SendingMessage)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = NewForwarder(out, sending).KeepWorking(ctx) }()
+	out <- NewPacket([]byte("push"))
+	if m := <-sending; string(m.Bytes()) != "push" {
+		t.Errorf("got %q", m.Bytes())
+	}
+}
@@ -0,0 +1,82 @@
+package tcp
+
+// Group 是一组共享中间件的路由规则。参考 echo 的 Group 。
+// 只有被路由组的 IdentifierFunc 匹配的消息才会经过路由组的中间件，然后按注册顺序检查组内的路由规则。
+// 如果组内没有路由规则匹配，则调用 Server 的默认处理函数（此时路由组的中间件已经执行过了）。
+type Group struct {
+	middleware []MiddlewareFunc
+	routers    []*RouterPair
+}
+
+func newGroup(middlewares []MiddlewareFunc) *Group {
+	g := &Group{}
+	g.Use(middlewares...)
+	return g
+}
+
+// Use 注册只对本路由组生效的中间件，先注册的先执行。
+func (g *Group) Use(middlewares ...MiddlewareFunc) {
+	g.middleware = append(g.middleware, middlewares...)
+}
+
+// Add 在本路由组内注册一条路由规则。middlewares 只对这条路由生效，在路由组的中间件之后执行。
+func (g *Group) Add(identifier IdentifierFunc, handler HandlerFunc, middlewares ...MiddlewareFunc) *RouterPair {
+	r := &RouterPair{
+		identifier: identifier,
+		handler:    handler,
+		middleware: middlewares,
+	}
+	g.routers = append(g.routers, r)
+	return r
+}
+
+// Group 在本路由组内注册一个子路由组。
+func (g *Group) Group(identifier IdentifierFunc, middlewares ...MiddlewareFunc) *Group {
+	sub := newGroup(middlewares)
+	g.routers = append(g.routers, &RouterPair{
+		identifier: identifier,
+		group:      sub,
+	})
+	return sub
+}
+
+// buildRouter 返回一个按顺序检查 routers 的 HandlerFunc 。都不匹配时调用 defaultHandler 。
+// 匹配时会把路由规则的名字写入 Context.SetRouteName 。
+func buildRouter(routers []*RouterPair, defaultHandler HandlerFunc) HandlerFunc {
+	handlers := make([]HandlerFunc, len(routers))
+	for i, r := range routers {
+		handlers[i] = r.build(defaultHandler)
+	}
+	return func(c Context) error {
+		m := c.Received()
+		for i, r := range routers {
+			if r.identifier(m) {
+				if r.name != "" {
+					c.SetRouteName(r.name)
+				}
+				return handlers[i](c)
+			}
+		}
+		if c.RouteName() == "" {
+			c.SetRouteName(DefaultRouteName)
+		}
+		return defaultHandler(c)
+	}
+}
+
+// build 返回这条路由规则包裹了中间件之后的 HandlerFunc 。
+func (r *RouterPair) build(defaultHandler HandlerFunc) HandlerFunc {
+	h := r.handler
+	if r.group != nil {
+		h = applyMiddleware(buildRouter(r.group.routers, defaultHandler), r.group.middleware)
+	}
+	return applyMiddleware(h, r.middleware)
+}
+
+// applyMiddleware 用 middlewares 包裹 h 。middlewares 里靠前的先执行。
+func applyMiddleware(h HandlerFunc, middlewares []MiddlewareFunc) HandlerFunc {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	return h
+}
@@ -0,0 +1,74 @@
+package tcp
+
+import (
+	"strings"
+	"testing"
+)
+
+func isPrefix(prefix string) IdentifierFunc {
+	return func(m ReceivedMessage) bool {
+		return strings.HasPrefix(string(m.(*Packet).Bytes()), prefix)
+	}
+}
+
+func recordMiddleware(trace *[]string, name string) MiddlewareFunc {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c Context) error {
+			*trace = append(*trace, name)
+			return next(c)
+		}
+	}
+}
+
+func recordHandler(trace *[]string, name string) HandlerFunc {
+	return func(c Context) error {
+		*trace = append(*trace, name)
+		return nil
+	}
+}
+
+func TestServer_Group(t *testing.T) {
+	var trace []string
+	s := NewServer()
+	s.Use(recordMiddleware(&trace, "global"))
+	s.Add(isPrefix("login"), recordHandler(&trace, "login"), recordMiddleware(&trace, "route"))
+	g := s.Group(isPrefix("auth."), recordMiddleware(&trace, "auth"))
+	g.Add(isPrefix("auth.get"), recordHandler(&trace, "get"))
+	sub := g.Group(isPrefix("auth.admin"), recordMiddleware(&trace, "admin"))
+	sub.Add(isPrefix("auth.admin.kick"), recordHandler(&trace, "kick"))
+	s.SetDefaultHandler(recordHandler(&trace, "default"))
+	h := s.buildHandler()
+
+	for _, tc := range []struct {
+		message string
+		want    string
+	}{
+		{"login", "global,route,login"},
+		{"auth.get", "global,auth,get"},
+		{"auth.put", "global,auth,default"},
+		{"auth.admin.kick", "global,auth,admin,kick"},
+		{"other", "global,default"},
+	} {
+		trace = nil
+		if err := h(&handleContext{received: NewPacket([]byte(tc.message))}); err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Join(trace, ","); got != tc.want {
+			t.Errorf("message %q: got %q, want %q", tc.message, got, tc.want)
+		}
+	}
+}
+
+func TestServer_RouteName(t *testing.T) {
+	s := NewServer()
+	s.Add(isPrefix("login"), func(c Context) error { return nil }).SetName("login")
+	s.SetDefaultHandler(func(c Context) error { return nil })
+	h := s.buildHandler()
+	for message, want := range map[string]string{"login": "login", "other": DefaultRouteName} {
+		c := &handleContext{received: NewPacket([]byte(message))}
+		_ = h(c)
+		if c.RouteName() != want {
+			t.Errorf("message %q: route name %q, want %q", message, c.RouteName(), want)
+		}
+	}
+}
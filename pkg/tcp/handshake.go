@@ -0,0 +1,99 @@
+package tcp
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"sync"
+)
+
+// HandshakeRouteName 是握手处理函数处理消息时的路由名字，用作指标和 Span 的标签。
+const HandshakeRouteName = "handshake"
+
+// session 是一个连接上所有消息共享的认证状态和值。
+type session struct {
+	mutex         sync.RWMutex
+	authenticated bool
+	principal     interface{}
+	values        map[string]sessionValue
+}
+
+type sessionValue struct {
+	value   interface{}
+	visible bool // 管理接口是否显示
+}
+
+func (s *session) setValue(key string, value interface{}, visible bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.values == nil {
+		s.values = make(map[string]sessionValue)
+	}
+	s.values[key] = sessionValue{value: value, visible: visible}
+}
+
+func (s *session) getValue(key string) interface{} {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.values[key].value
+}
+
+// visibleValues 返回所有 visible 的值。
+func (s *session) visibleValues() map[string]interface{} {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	values := make(map[string]interface{})
+	for key, v := range s.values {
+		if v.visible {
+			values[key] = v.value
+		}
+	}
+	return values
+}
+
+func (s *session) authenticate(principal interface{}) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.authenticated = true
+	s.principal = principal
+}
+
+func (s *session) isAuthenticated() bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.authenticated
+}
+
+func (s *session) getPrincipal() interface{} {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.principal
+}
+
+// keepHandshaking 把收到的消息逐条交给握手处理函数，直到某次处理调用了 Context.Authenticate 。
+// 握手处理函数返回错误时返回 HandshakeFailed ，超过 HandshakeTimeout 仍未通过认证时返回 HandshakeTimedOut 。
+// 握手期间不论 ProcessingMode 是什么都逐条处理，也不经过中间件和路由。
+func (p *Processor) keepHandshaking(ctx context.Context) error {
+	if p.config.HandshakeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, p.config.HandshakeTimeout, HandshakeTimedOut)
+		defer cancel()
+	}
+	for !p.session.isAuthenticated() {
+		select {
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		case m, ok := <-p.receivedMessageChannel:
+			if !ok {
+				return errors.New("channel is closed")
+			}
+			c := p.newContext(ctx, m)
+			c.routeName = HandshakeRouteName
+			if err := p.process(c, p.config.Handshake); err != nil {
+				return errors.Wrapf(HandshakeFailed, "%v", err)
+			}
+			p.finishSequentially(ctx)
+		}
+	}
+	p.logger.Debug("tcp: authenticated")
+	return nil
+}
@@ -0,0 +1,92 @@
+package tcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestProcessor_KeepWorking_handshake(t *testing.T) {
+	received := make(chan ReceivedMessage)
+	sending := make(chan SendingMessage)
+	handshakeErrors := 0
+	p := newTestProcessor(t, &DaemonConfig{
+		Handshake: func(c Context) error {
+			switch string(c.Received().(*Packet).Bytes()) {
+			case "login":
+				c.Authenticate("alice")
+				c.Send(NewPacket([]byte("welcome")))
+				return nil
+			case "bad password":
+				return errors.New("bad password")
+			default:
+				c.Send(NewPacket([]byte("login first")))
+				return nil
+			}
+		},
+		Handler: func(c Context) error {
+			c.Send(NewPacket([]byte(fmt.Sprintf("%s: %s", c.Principal(), c.Received().(*Packet).Bytes()))))
+			return nil
+		},
+		ErrorHandler: func(c Context, err error) {
+			handshakeErrors++
+		},
+	}, received, sending)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = p.KeepWorking(ctx) }()
+	for _, c := range []struct{ frame, reply string }{
+		{"hello", "login first"},
+		{"login", "welcome"},
+		{"hello", "alice: hello"},
+		{"login", "alice: login"}, // 认证之后不再经过握手处理函数
+	} {
+		received <- NewPacket([]byte(c.frame))
+		if got := string((<-sending).Bytes()); got != c.reply {
+			t.Errorf("%s: got %q, want %q", c.frame, got, c.reply)
+		}
+	}
+	if handshakeErrors != 0 {
+		t.Errorf("error handler called %d times", handshakeErrors)
+	}
+}
+
+func TestProcessor_KeepWorking_handshakeFailed(t *testing.T) {
+	received := make(chan ReceivedMessage, 1)
+	var handled error
+	p := newTestProcessor(t, &DaemonConfig{
+		Handshake: func(c Context) error {
+			return errors.New("bad password")
+		},
+		Handler: func(c Context) error {
+			t.Error("handler called before authentication")
+			return nil
+		},
+		ErrorHandler: func(c Context, err error) {
+			handled = err
+		},
+		HandshakeTimeout: time.Second,
+	}, received, nil)
+	received <- NewPacket([]byte("login"))
+	if err := p.KeepWorking(context.Background()); !errors.Is(err, HandshakeFailed) {
+		t.Errorf("got %v, want HandshakeFailed", err)
+	}
+	if handled == nil {
+		t.Error("handshake error is not passed to the error handler")
+	}
+	if reason := DisconnectReason(HandshakeFailed); reason != "handshake_failed" {
+		t.Errorf("disconnect reason %q", reason)
+	}
+}
+
+func TestProcessor_KeepWorking_handshakeTimedOut(t *testing.T) {
+	p := newTestProcessor(t, &DaemonConfig{
+		Handshake:        func(c Context) error { return nil },
+		HandshakeTimeout: time.Millisecond * 10,
+	}, make(chan ReceivedMessage), nil)
+	if err := p.KeepWorking(context.Background()); !errors.Is(err, HandshakeTimedOut) {
+		t.Errorf("got %v, want HandshakeTimedOut", err)
+	}
+}
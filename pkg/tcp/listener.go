@@ -1,12 +1,61 @@
 package tcp
 
 import (
+	"context"
+	"log/slog"
 	"net"
+	"sync/atomic"
+	"time"
 )
 
 type Connection struct {
 	connectionID ConnectionID
 	conn         net.Conn
+	connectedAt  time.Time
+	stats        connectionStats
+	session      *session                // 连接的认证状态和值，由 Processor 和 Server.Connections 共享
+	info         *ConnectionInfo         // 通过准入之后的连接信息
+	cancel       context.CancelCauseFunc // 中断连接，由 Server.Disconnect 调用
+	idError      error                   // 生成连接 ID 出错，见 FallibleGenerator 。Server 会拒绝这个连接
+}
+
+// connectionStats 是一个连接的收发统计，由 Receiver 和 Sender 更新。
+type connectionStats struct {
+	bytesReceived  atomic.Int64
+	bytesSent      atomic.Int64
+	framesReceived atomic.Int64
+	framesSent     atomic.Int64
+	sendQueueDepth atomic.Int64 // 放入了待发送消息队列、还没有发送完的消息数
+}
+
+// enqueue 在一条消息放入待发送消息队列之前调用，返回还没有发送完的消息数（包括这一条）。
+func (s *connectionStats) enqueue() int {
+	return int(s.sendQueueDepth.Add(1))
+}
+
+// dequeue 在一条消息发送完、发送失败或者被丢弃之后调用。
+func (s *connectionStats) dequeue() {
+	s.sendQueueDepth.Add(-1)
+}
+
+func (c *Connection) ID() ConnectionID {
+	return c.connectionID
+}
+
+// RemoteAddr 返回连接的远端地址。没有底层的 net.Conn 时（见 NewProcessor ）返回 nil 。
+func (c *Connection) RemoteAddr() net.Addr {
+	if c.conn == nil {
+		return nil
+	}
+	return c.conn.RemoteAddr()
+}
+
+// logger 返回带有连接 ID 和远端地址的 logger 。
+func (c *Connection) logger(base *slog.Logger) *slog.Logger {
+	return base.With(
+		slog.String("connection_id", string(c.connectionID)),
+		slog.String("remote_addr", c.conn.RemoteAddr().String()),
+	)
 }
 
 type Listener struct {
@@ -35,26 +84,48 @@ func (l *Listener) Start(address string) (<-chan *Connection, error) {
 	if ln, err := net.Listen("tcp", address); err != nil {
 		return nil, err
 	} else {
-		l.listener = ln
+		return l.Serve(ln), nil
 	}
+}
+
+// Serve 和 Start 一样，但是在外部创建好的 ln 上接受连接（例如 tls.NewListener 返回的 net.Listener ）。
+// Stop 会关闭 ln 。连接 ID 生成出错时仍然返回这个连接，它的 ID 是空的。
+func (l *Listener) Serve(ln net.Listener) <-chan *Connection {
+	l.listener = ln
 	connections := make(chan *Connection)
 	go func() {
 		defer close(connections)
-		//fmt.Println("tcp.listener.proc start!")
-		//defer fmt.Println("tcp.listener.proc exit!")
 		for {
 			if conn, err := l.listener.Accept(); err != nil {
 				l.err = err
 				break
 			} else {
+				connectionID, err := l.nextConnectionID()
 				connections <- &Connection{
-					connectionID: ConnectionID(l.connectionIDGenerator.Next()),
+					connectionID: connectionID,
 					conn:         conn,
+					connectedAt:  time.Now(),
+					session:      &session{},
+					idError:      err,
 				}
 			}
 		}
 	}()
-	return connections, nil
+	return connections
+}
+
+// nextConnectionID 生成新连接的 ID 。
+func (l *Listener) nextConnectionID() (ConnectionID, error) {
+	if g, ok := l.connectionIDGenerator.(FallibleGenerator); ok {
+		id, err := g.Generate()
+		return ConnectionID(id), err
+	}
+	return ConnectionID(l.connectionIDGenerator.Next()), nil
+}
+
+// Addr 返回监听的地址。
+func (l *Listener) Addr() net.Addr {
+	return l.listener.Addr()
 }
 
 // Stop 停止监听。
@@ -0,0 +1,43 @@
+package tcp
+
+import (
+	"github.com/seedjyh/go-tcp/pkg/tcp/uuid"
+	"net"
+	"testing"
+)
+
+// countListener 的 Accept 先返回 n 次 conn ，之后返回 net.ErrClosed 。
+type countListener struct {
+	n    int
+	conn net.Conn
+}
+
+func (l *countListener) Accept() (net.Conn, error) {
+	if l.n == 0 {
+		return nil, net.ErrClosed
+	}
+	l.n--
+	return l.conn, nil
+}
+
+func (l *countListener) Close() error   { return nil }
+func (l *countListener) Addr() net.Addr { return l.conn.LocalAddr() }
+
+// BenchmarkListener_Serve 测量接受连接的路径上，生成连接 ID 和创建 Connection 的开销。
+func BenchmarkListener_Serve(b *testing.B) {
+	generators := map[string]Generator{
+		"uuid32":   uuid.NewUUID32Generator(),
+		"sortable": uuid.NewSortableGenerator(uuid.Config{}),
+	}
+	for name, g := range generators {
+		b.Run(name, func(b *testing.B) {
+			client, server := net.Pipe()
+			defer client.Close()
+			defer server.Close()
+			b.ReportAllocs()
+			b.ResetTimer()
+			for range NewListener(g).Serve(&countListener{n: b.N, conn: server}) {
+			}
+		})
+	}
+}
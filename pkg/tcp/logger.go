@@ -0,0 +1,12 @@
+package tcp
+
+import "log/slog"
+
+// errorAttr 把 err 转成日志字段。
+// github.com/pkg/errors 生成的错误在 slog 里会带上调用栈，这里只保留错误信息。
+func errorAttr(key string, err error) slog.Attr {
+	if err == nil {
+		return slog.Any(key, nil)
+	}
+	return slog.String(key, err.Error())
+}
@@ -0,0 +1,118 @@
+package tcp
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"log/slog"
+	"net"
+	"sync"
+	"testing"
+)
+
+type logRecord struct {
+	level   slog.Level
+	message string
+	attrs   map[string]string
+}
+
+// logRecorder 把日志记录在内存里，WithAttrs 添加的字段也会记录。
+type logRecorder struct {
+	mutex   *sync.Mutex
+	records *[]logRecord
+	attrs   []slog.Attr
+}
+
+func newLogRecorder() *logRecorder {
+	return &logRecorder{mutex: &sync.Mutex{}, records: &[]logRecord{}}
+}
+
+func (h *logRecorder) Enabled(context.Context, slog.Level) bool { return true }
+
+func (h *logRecorder) Handle(_ context.Context, r slog.Record) error {
+	record := logRecord{level: r.Level, message: r.Message, attrs: make(map[string]string)}
+	for _, a := range h.attrs {
+		record.attrs[a.Key] = a.Value.String()
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		record.attrs[a.Key] = a.Value.String()
+		return true
+	})
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	*h.records = append(*h.records, record)
+	return nil
+}
+
+func (h *logRecorder) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &logRecorder{mutex: h.mutex, records: h.records, attrs: append(append([]slog.Attr{}, h.attrs...), attrs...)}
+}
+
+func (h *logRecorder) WithGroup(string) slog.Handler { return h }
+
+// find 返回所有消息是 message 的记录。
+func (h *logRecorder) find(message string) []logRecord {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	var found []logRecord
+	for _, r := range *h.records {
+		if r.message == message {
+			found = append(found, r)
+		}
+	}
+	return found
+}
+
+func TestServer_SetLogHandler_events(t *testing.T) {
+	client, server := net.Pipe()
+	logs := newLogRecorder()
+	handled := make(chan struct{}, 2)
+	s := NewServer()
+	s.SetLogHandler(logs)
+	s.SetDefaultHandler(func(c Context) error {
+		if string(c.Received().(*Packet).Bytes()) == "panic" {
+			panic("boom")
+		}
+		return errors.New("bad message")
+	})
+	s.SetErrorHandler(func(c Context, err error) {
+		DefaultErrorHandler(c, err)
+		handled <- struct{}{}
+	})
+	done := make(chan error)
+	go func() { done <- s.Serve(newPipeListener(server)) }()
+	// net.Pipe 的每次写入都是 Receiver 的一次收取，所以这是两条消息。
+	for _, m := range []string{"error", "panic"} {
+		if _, err := client.Write([]byte(m)); err != nil {
+			t.Fatal(err)
+		}
+		<-handled
+	}
+	_ = client.Close()
+	_ = s.Stop()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	for _, message := range []string{"tcp: connected", "tcp: disconnected"} {
+		if found := logs.find(message); len(found) != 1 {
+			t.Errorf("%q logged %d times, want 1", message, len(found))
+		} else if found[0].attrs["connection_id"] == "" || found[0].attrs["remote_addr"] == "" {
+			t.Errorf("%q has no connection attributes: %v", message, found[0].attrs)
+		} else if _, ok := found[0].attrs["reason"]; message == "tcp: disconnected" && !ok {
+			t.Errorf("%q has no reason: %v", message, found[0].attrs)
+		}
+	}
+	if found := logs.find("tcp: handle message failed"); len(found) != 2 {
+		t.Fatalf("handle message failed logged %d times, want 2", len(found))
+	} else {
+		if found[0].level != slog.LevelError || found[0].attrs["error"] != "bad message" {
+			t.Errorf("bad error record %+v", found[0])
+		}
+		if _, ok := found[0].attrs["stack"]; ok {
+			t.Errorf("error record has a stack: %v", found[0].attrs)
+		}
+		if found[1].level != slog.LevelError || found[1].attrs["stack"] == "" || found[1].attrs["connection_id"] == "" {
+			t.Errorf("bad panic record %+v", found[1])
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package tcp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"time"
+)
+
+// MetricsCollector 收集服务的运行指标。核心包不依赖任何指标库，由实现决定如何导出（例如 metrics 子包导出成 Prometheus 文本格式）。
+// 所有方法都可能被多个协程并发调用，不应该阻塞。
+// 自定义实现可以内嵌 NopMetricsCollector ，只覆盖关心的方法。
+type MetricsCollector interface {
+	// ConnectionAccepted 一个新连接通过了准入（见 Server.SetAdmission 和 Server.SetProxyProtocol）。被拒绝的连接只计入 ConnectionRejected 。
+	ConnectionAccepted()
+	// ConnectionRejected 新连接在建立 Daemon 之前被拒绝。
+	ConnectionRejected(reason string)
+	// ConnectionOpened 连接的 Daemon 开始工作。
+	ConnectionOpened()
+	// ConnectionClosed 连接的 Daemon 结束工作。reason 是 DisconnectReason 的返回值。
+	ConnectionClosed(reason string)
+	// BytesReceived 从连接收到 n 字节。
+	BytesReceived(n int)
+	// BytesSent 向连接发出 n 字节。
+	BytesSent(n int)
+	// FrameReceived 拆分出一个完整的包。
+	FrameReceived()
+	// FrameSent 发送完一个完整的包。调用时这个包已经计入 Server.Connections 的统计。
+	FrameSent()
+	// HandlerDuration 一条消息从进入中间件到处理完成的耗时。route 是 Context.RouteName() 。
+	HandlerDuration(route string, d time.Duration)
+	// SendQueueDepth 一条消息放入待发送消息队列时，这个连接还没有发送完的消息数（包括这一条和正在发送的一条）。
+	SendQueueDepth(depth int)
+	// MessageDropped 一条待发送的消息因为连接已经关闭而被丢弃。
+	MessageDropped()
+	// MessageRateLimited 一条收到的消息超过了限速。policy 是 RateLimitPolicy.String() 。
+	MessageRateLimited(policy string)
+}
+
+// NopMetricsCollector 什么都不做。是 Server 的默认值。
+type NopMetricsCollector struct{}
+
+func (NopMetricsCollector) ConnectionAccepted()                   {}
+func (NopMetricsCollector) ConnectionRejected(string)             {}
+func (NopMetricsCollector) ConnectionOpened()                     {}
+func (NopMetricsCollector) ConnectionClosed(string)               {}
+func (NopMetricsCollector) BytesReceived(int)                     {}
+func (NopMetricsCollector) BytesSent(int)                         {}
+func (NopMetricsCollector) FrameReceived()                        {}
+func (NopMetricsCollector) FrameSent()                            {}
+func (NopMetricsCollector) HandlerDuration(string, time.Duration) {}
+func (NopMetricsCollector) SendQueueDepth(int)                    {}
+func (NopMetricsCollector) MessageDropped()                       {}
+func (NopMetricsCollector) MessageRateLimited(string)             {}
+
+// DisconnectReason 把连接中断的原因归类成适合作为指标标签的短字符串。
+func DisconnectReason(err error) string {
+	var netErr net.Error
+	switch {
+	case err == nil:
+		return "unknown"
+	case errors.Is(err, ConnectionClosedByHandler):
+		return "closed_by_handler"
+	case errors.Is(err, ConnectionDisconnected):
+		return "disconnected"
+	case errors.Is(err, ServerStopped):
+		return "server_stopped"
+	case errors.Is(err, HandshakeFailed):
+		return "handshake_failed"
+	case errors.Is(err, HandshakeTimedOut):
+		return "handshake_timeout"
+	case errors.Is(err, io.EOF):
+		return "eof"
+	case errors.Is(err, os.ErrDeadlineExceeded), errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.As(err, &netErr):
+		return "network_error"
+	default:
+		return "error"
+	}
+}
@@ -0,0 +1,256 @@
+// Package metrics 提供 tcp.MetricsCollector 的一个实现，可以导出成 Prometheus 文本格式，不依赖 Prometheus 客户端库。
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/seedjyh/go-tcp/pkg/tcp"
+)
+
+// DefaultDurationBuckets 是处理耗时直方图的默认分桶上界（秒）。
+var DefaultDurationBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// DefaultQueueDepthBuckets 是待发送消息队列深度直方图的默认分桶上界。
+var DefaultQueueDepthBuckets = []float64{0, 1, 2, 4, 8, 16, 32, 64, 128, 256}
+
+// Registry 实现了 tcp.MetricsCollector 。
+// 用 WritePrometheus 输出 Prometheus 文本格式，或者直接作为 http.Handler 挂到 /metrics 。
+// 多个 Server 可以共享一个 Registry 。
+type Registry struct {
+	namespace string
+
+	activeConnections   int64
+	acceptedConnections uint64
+	receivedBytes       uint64
+	sentBytes           uint64
+	receivedFrames      uint64
+	sentFrames          uint64
+	droppedMessages     uint64
+
+	mutex              sync.Mutex
+	rejectedByReason   map[string]uint64
+	closedByReason     map[string]uint64
+	limitedByPolicy    map[string]uint64
+	durationByRoute    map[string]*histogram
+	queueDepth         *histogram
+	durationBucketsSec []float64
+}
+
+var _ tcp.MetricsCollector = (*Registry)(nil)
+
+// NewRegistry 创建一个 Registry 。namespace 是所有指标名的前缀，例如 "tcp" 会生成 tcp_connections_active 。
+func NewRegistry(namespace string) *Registry {
+	return &Registry{
+		namespace:          namespace,
+		rejectedByReason:   make(map[string]uint64),
+		closedByReason:     make(map[string]uint64),
+		limitedByPolicy:    make(map[string]uint64),
+		durationByRoute:    make(map[string]*histogram),
+		queueDepth:         newHistogram(DefaultQueueDepthBuckets),
+		durationBucketsSec: DefaultDurationBuckets,
+	}
+}
+
+func (r *Registry) ConnectionAccepted() {
+	atomic.AddUint64(&r.acceptedConnections, 1)
+}
+
+func (r *Registry) ConnectionRejected(reason string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.rejectedByReason[reason]++
+}
+
+func (r *Registry) ConnectionOpened() {
+	atomic.AddInt64(&r.activeConnections, 1)
+}
+
+func (r *Registry) ConnectionClosed(reason string) {
+	atomic.AddInt64(&r.activeConnections, -1)
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.closedByReason[reason]++
+}
+
+func (r *Registry) BytesReceived(n int) {
+	atomic.AddUint64(&r.receivedBytes, uint64(n))
+}
+
+func (r *Registry) BytesSent(n int) {
+	atomic.AddUint64(&r.sentBytes, uint64(n))
+}
+
+func (r *Registry) FrameReceived() {
+	atomic.AddUint64(&r.receivedFrames, 1)
+}
+
+func (r *Registry) FrameSent() {
+	atomic.AddUint64(&r.sentFrames, 1)
+}
+
+func (r *Registry) HandlerDuration(route string, d time.Duration) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	h, ok := r.durationByRoute[route]
+	if !ok {
+		h = newHistogram(r.durationBucketsSec)
+		r.durationByRoute[route] = h
+	}
+	h.observe(d.Seconds())
+}
+
+func (r *Registry) SendQueueDepth(depth int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.queueDepth.observe(float64(depth))
+}
+
+func (r *Registry) MessageDropped() {
+	atomic.AddUint64(&r.droppedMessages, 1)
+}
+
+func (r *Registry) MessageRateLimited(policy string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.limitedByPolicy[policy]++
+}
+
+// ServeHTTP 以 Prometheus 文本格式输出所有指标。
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_ = r.WritePrometheus(w)
+}
+
+// WritePrometheus 以 Prometheus 文本格式输出所有指标。
+func (r *Registry) WritePrometheus(w io.Writer) error {
+	pw := &promWriter{w: w, namespace: r.namespace}
+	pw.single("connections_active", "gauge", "Number of connections currently being served.", float64(atomic.LoadInt64(&r.activeConnections)))
+	pw.single("connections_accepted_total", "counter", "Total number of connections that passed admission.", float64(atomic.LoadUint64(&r.acceptedConnections)))
+	pw.single("received_bytes_total", "counter", "Total number of bytes read from connections.", float64(atomic.LoadUint64(&r.receivedBytes)))
+	pw.single("sent_bytes_total", "counter", "Total number of bytes written to connections.", float64(atomic.LoadUint64(&r.sentBytes)))
+	pw.single("received_frames_total", "counter", "Total number of frames split from connections.", float64(atomic.LoadUint64(&r.receivedFrames)))
+	pw.single("sent_frames_total", "counter", "Total number of frames written to connections.", float64(atomic.LoadUint64(&r.sentFrames)))
+	pw.single("dropped_messages_total", "counter", "Total number of outbound messages dropped because the connection was closed.", float64(atomic.LoadUint64(&r.droppedMessages)))
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	pw.labeled("connections_rejected_total", "counter", "Total number of connections rejected before being served.", "reason", r.rejectedByReason)
+	pw.labeled("disconnects_total", "counter", "Total number of closed connections by reason.", "reason", r.closedByReason)
+	pw.labeled("rate_limited_messages_total", "counter", "Total number of received messages that exceeded a rate limit, by policy.", "policy", r.limitedByPolicy)
+	pw.header("handler_duration_seconds", "histogram", "Time spent handling one message, by route.")
+	for _, route := range sortedKeys(r.durationByRoute) {
+		pw.histogram("handler_duration_seconds", fmt.Sprintf(`route="%s"`, escapeLabel(route)), r.durationByRoute[route])
+	}
+	pw.header("send_queue_depth", "histogram", "Messages queued or being written on the connection when one more is queued.")
+	pw.histogram("send_queue_depth", "", r.queueDepth)
+	return pw.err
+}
+
+type histogram struct {
+	upperBounds []float64
+	counts      []uint64 // 每个分桶（不累加）的计数，最后一个是 +Inf
+	sum         float64
+	count       uint64
+}
+
+func newHistogram(upperBounds []float64) *histogram {
+	return &histogram{
+		upperBounds: upperBounds,
+		counts:      make([]uint64, len(upperBounds)+1),
+	}
+}
+
+func (h *histogram) observe(v float64) {
+	i := sort.SearchFloat64s(h.upperBounds, v)
+	h.counts[i]++
+	h.sum += v
+	h.count++
+}
+
+// promWriter 输出 Prometheus 文本格式，记录第一个写入错误。
+type promWriter struct {
+	w         io.Writer
+	namespace string
+	err       error
+}
+
+func (pw *promWriter) printf(format string, args ...interface{}) {
+	if pw.err != nil {
+		return
+	}
+	_, pw.err = fmt.Fprintf(pw.w, format, args...)
+}
+
+func (pw *promWriter) name(name string) string {
+	if pw.namespace == "" {
+		return name
+	}
+	return pw.namespace + "_" + name
+}
+
+func (pw *promWriter) header(name, typ, help string) {
+	pw.printf("# HELP %s %s\n# TYPE %s %s\n", pw.name(name), help, pw.name(name), typ)
+}
+
+func (pw *promWriter) single(name, typ, help string, value float64) {
+	pw.header(name, typ, help)
+	pw.printf("%s %s\n", pw.name(name), formatFloat(value))
+}
+
+func (pw *promWriter) labeled(name, typ, help, label string, values map[string]uint64) {
+	pw.header(name, typ, help)
+	for _, k := range sortedKeys(values) {
+		pw.printf("%s{%s=\"%s\"} %d\n", pw.name(name), label, escapeLabel(k), values[k])
+	}
+}
+
+func (pw *promWriter) histogram(name, labels string, h *histogram) {
+	withLabels := func(extra string) string {
+		switch {
+		case labels == "" && extra == "":
+			return ""
+		case labels == "":
+			return "{" + extra + "}"
+		case extra == "":
+			return "{" + labels + "}"
+		default:
+			return "{" + labels + "," + extra + "}"
+		}
+	}
+	var cumulative uint64
+	for i, upperBound := range h.upperBounds {
+		cumulative += h.counts[i]
+		pw.printf("%s_bucket%s %d\n", pw.name(name), withLabels(fmt.Sprintf(`le="%s"`, formatFloat(upperBound))), cumulative)
+	}
+	pw.printf("%s_bucket%s %d\n", pw.name(name), withLabels(`le="+Inf"`), h.count)
+	pw.printf("%s_sum%s %s\n", pw.name(name), withLabels(""), formatFloat(h.sum))
+	pw.printf("%s_count%s %d\n", pw.name(name), withLabels(""), h.count)
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, +1) {
+		return "+Inf"
+	}
+	return fmt.Sprintf("%g", v)
+}
+
+func escapeLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,48 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRegistry_WritePrometheus(t *testing.T) {
+	r := NewRegistry("tcp")
+	r.ConnectionAccepted()
+	r.ConnectionOpened()
+	r.ConnectionAccepted()
+	r.ConnectionOpened()
+	r.ConnectionClosed("eof")
+	r.ConnectionRejected("denied")
+	r.BytesReceived(10)
+	r.BytesSent(7)
+	r.FrameReceived()
+	r.FrameSent()
+	r.HandlerDuration("login", 3*time.Millisecond)
+	r.HandlerDuration("login", 2*time.Second)
+	r.SendQueueDepth(3)
+
+	buf := bytes.NewBuffer(nil)
+	if err := r.WritePrometheus(buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"# TYPE tcp_connections_active gauge\ntcp_connections_active 1\n",
+		"tcp_connections_accepted_total 2\n",
+		`tcp_connections_rejected_total{reason="denied"} 1` + "\n",
+		`tcp_disconnects_total{reason="eof"} 1` + "\n",
+		"tcp_received_bytes_total 10\n",
+		"tcp_sent_bytes_total 7\n",
+		`tcp_handler_duration_seconds_bucket{route="login",le="0.0025"} 0` + "\n",
+		`tcp_handler_duration_seconds_bucket{route="login",le="0.005"} 1` + "\n",
+		`tcp_handler_duration_seconds_bucket{route="login",le="+Inf"} 2` + "\n",
+		`tcp_handler_duration_seconds_count{route="login"} 2` + "\n",
+		`tcp_send_queue_depth_bucket{le="4"} 1` + "\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output does not contain %q:\n%s", want, out)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package tcp
+
+import (
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// pipeListener 的 Accept 返回一次 conn ，之后阻塞到 Close 。
+type pipeListener struct {
+	conns  chan net.Conn
+	closed chan struct{}
+}
+
+func newPipeListener(conn net.Conn) *pipeListener {
+	l := &pipeListener{conns: make(chan net.Conn, 1), closed: make(chan struct{})}
+	l.conns <- conn
+	return l
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *pipeListener) Close() error {
+	select {
+	case <-l.closed:
+	default:
+		close(l.closed)
+	}
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr { return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)} }
+
+// depthCollector 记录最大的待发送消息数，在 SendQueueDepth 第一次报告 2 的时候关闭 reachedTwo ，
+// 每发送完一个包向 sent 写入一次。
+type depthCollector struct {
+	NopMetricsCollector
+	maxDepth   atomic.Int64
+	reachedTwo chan struct{}
+	once       sync.Once
+	sent       chan struct{}
+}
+
+func (c *depthCollector) SendQueueDepth(depth int) {
+	for {
+		old := c.maxDepth.Load()
+		if int64(depth) <= old || c.maxDepth.CompareAndSwap(old, int64(depth)) {
+			break
+		}
+	}
+	if depth == 2 {
+		c.once.Do(func() { close(c.reachedTwo) })
+	}
+}
+
+func (c *depthCollector) FrameSent() {
+	c.sent <- struct{}{}
+}
+
+func TestServer_sendQueueDepth(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	metrics := &depthCollector{reachedTwo: make(chan struct{}), sent: make(chan struct{}, 3)}
+	s := NewServer()
+	s.SetLogHandler(slog.NewTextHandler(io.Discard, nil))
+	s.SetMetricsCollector(metrics)
+	s.SetDefaultHandler(func(c Context) error {
+		for i := 0; i < 3; i++ {
+			c.Send(NewPacket([]byte("reply")))
+		}
+		return nil
+	})
+	done := make(chan error)
+	go func() { done <- s.Serve(newPipeListener(server)) }()
+	defer func() {
+		_ = s.Stop()
+		<-done
+	}()
+	if _, err := client.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	// 客户端不读，Sender 阻塞在第一条响应上，第二条响应阻塞在 Send 里
+	<-metrics.reachedTwo
+	if depth := s.Connections()[0].SendQueueDepth; depth != 2 || metrics.maxDepth.Load() != 2 {
+		t.Errorf("got depth %d, reported %d, want 2", depth, metrics.maxDepth.Load())
+	}
+	buf := make([]byte, 64)
+	for i := 0; i < 3; i++ {
+		if _, err := client.Read(buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		<-metrics.sent
+	}
+	if depth := s.Connections()[0].SendQueueDepth; depth != 0 {
+		t.Errorf("got depth %d after the client read everything", depth)
+	}
+}
@@ -0,0 +1,66 @@
+// Package oteltrace 把 OpenTelemetry 的 trace.Tracer 适配成 tcp.Tracer 。
+//
+//	s.SetTracer(oteltrace.NewTracer(otel.Tracer("my-service")))
+//
+// 处理函数可以用 trace.SpanFromContext(c.Context()) 得到当前消息的 Span ，
+// 并把 c.Context() 传给下游调用，使下游的 Span 成为它的子 Span 。
+package oteltrace
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/seedjyh/go-tcp/pkg/tcp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type tracer struct {
+	tracer trace.Tracer
+}
+
+// NewTracer 返回一个用 t 创建 Span 的 tcp.Tracer 。Span 的类型是 trace.SpanKindServer 。
+func NewTracer(t trace.Tracer) tcp.Tracer {
+	return &tracer{tracer: t}
+}
+
+func (t *tracer) Start(ctx context.Context, name string) (context.Context, tcp.Span) {
+	ctx, s := t.tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindServer))
+	return ctx, &span{span: s}
+}
+
+type span struct {
+	span trace.Span
+}
+
+func (s *span) SetAttribute(key string, value interface{}) {
+	s.span.SetAttributes(toAttribute(key, value))
+}
+
+func (s *span) End(err error) {
+	if err != nil {
+		s.span.RecordError(err)
+		s.span.SetStatus(codes.Error, err.Error())
+	}
+	s.span.End()
+}
+
+func toAttribute(key string, value interface{}) attribute.KeyValue {
+	switch v := value.(type) {
+	case string:
+		return attribute.String(key, v)
+	case int:
+		return attribute.Int(key, v)
+	case int64:
+		return attribute.Int64(key, v)
+	case bool:
+		return attribute.Bool(key, v)
+	case float64:
+		return attribute.Float64(key, v)
+	case fmt.Stringer:
+		return attribute.Stringer(key, v)
+	default:
+		return attribute.String(key, fmt.Sprint(v))
+	}
+}
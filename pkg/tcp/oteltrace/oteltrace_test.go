@@ -0,0 +1,73 @@
+package oteltrace
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestToAttribute(t *testing.T) {
+	for _, c := range []struct {
+		value interface{}
+		want  attribute.Value
+	}{
+		{"abc", attribute.StringValue("abc")},
+		{42, attribute.IntValue(42)},
+		{int64(1) << 40, attribute.Int64Value(1 << 40)},
+		{true, attribute.BoolValue(true)},
+		{1.5, attribute.Float64Value(1.5)},
+		{time.Second, attribute.StringValue("1s")},    // fmt.Stringer
+		{[]int{1, 2}, attribute.StringValue("[1 2]")}, // 其他类型用 fmt.Sprint
+		{int32(7), attribute.StringValue("7")},        // 没有单独处理的整数类型
+	} {
+		if got := toAttribute("key", c.value); got.Key != "key" || got.Value != c.want {
+			t.Errorf("%T %v: got %v %s, want %v %s", c.value, c.value, got.Value.Type(), got.Value.Emit(), c.want.Type(), c.want.Emit())
+		}
+	}
+}
+
+func newRecordingTracer() (*tracetest.SpanRecorder, *tracer) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	return recorder, NewTracer(provider.Tracer("test")).(*tracer)
+}
+
+func TestSpan_End(t *testing.T) {
+	recorder, tr := newRecordingTracer()
+	_, s := tr.Start(context.Background(), "tcp.message")
+	s.SetAttribute("tcp.route", "login")
+	s.End(errors.New("bad message"))
+	_, s = tr.Start(context.Background(), "tcp.message")
+	s.End(nil)
+
+	ended := recorder.Ended()
+	if len(ended) != 2 {
+		t.Fatalf("%d spans ended, want 2", len(ended))
+	}
+	failed, ok := ended[0], ended[1]
+	if failed.SpanKind() != trace.SpanKindServer {
+		t.Errorf("span kind %v", failed.SpanKind())
+	}
+	if status := failed.Status(); status.Code != codes.Error || status.Description != "bad message" {
+		t.Errorf("failed span status %+v", status)
+	}
+	if events := failed.Events(); len(events) != 1 || events[0].Name != "exception" {
+		t.Errorf("failed span events %+v", events)
+	}
+	if attrs := failed.Attributes(); len(attrs) != 1 || attrs[0] != attribute.String("tcp.route", "login") {
+		t.Errorf("failed span attributes %v", attrs)
+	}
+	if status := ok.Status(); status.Code != codes.Unset {
+		t.Errorf("ok span status %+v", status)
+	}
+	if events := ok.Events(); len(events) != 0 {
+		t.Errorf("ok span events %+v", events)
+	}
+}
@@ -0,0 +1,32 @@
+package tcp
+
+import "fmt"
+
+// PanicPolicy 决定处理函数 panic 之后如何处理该连接。
+type PanicPolicy int
+
+const (
+	// PanicContinue 交给 ErrorHandlerFunc 处理后，继续处理该连接的后续消息。
+	PanicContinue PanicPolicy = iota
+	// PanicClose 交给 ErrorHandlerFunc 处理后，关闭该连接。其他连接不受影响。
+	PanicClose
+)
+
+// PanicError 是中间件、识别器或处理函数 panic 时，交给 ErrorHandlerFunc 的错误。
+type PanicError struct {
+	ConnectionID ConnectionID
+	Value        interface{} // recover() 的返回值
+	Stack        []byte      // panic 时的调用栈
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic in handler, connID=%s: %v", e.ConnectionID, e.Value)
+}
+
+// Unwrap 在 panic 的值本身是 error 时返回它。
+func (e *PanicError) Unwrap() error {
+	if err, ok := e.Value.(error); ok {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package tcp
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"hash/fnv"
+	"runtime/debug"
+	"sync"
+)
+
+// PoolStopped 是 WorkerPool 停止后再派发任务时返回的错误。
+var PoolStopped = errors.New("worker pool stopped")
+
+// KeyFunc 返回消息在 WorkerPool 里的分组键。同一个键的消息会按派发顺序依次处理。
+type KeyFunc func(c Context) string
+
+// ByConnectionID 按连接分组，同一个连接的消息按收到的顺序处理。
+func ByConnectionID(c Context) string {
+	return string(c.ConnectionID())
+}
+
+// WorkerPool 是所有连接共享的有界协程池，用于异步处理模式。
+// 每个 worker 有自己的队列，同一个键的任务总是派发给同一个 worker ，所以同一个键的任务按派发顺序处理。
+// worker 的队列满时，派发会阻塞。这会阻塞连接的 Processor ，进而阻塞 Receiver 收取数据，形成背压。
+type WorkerPool struct {
+	queues  []chan *poolTask
+	mutex   sync.RWMutex
+	stopped bool
+	wg      sync.WaitGroup
+}
+
+type poolTask struct {
+	c       Context
+	handler HandlerFunc
+	cancel  context.CancelFunc // 执行完后释放 c 的 context.Context
+}
+
+// NewWorkerPool 创建并启动一个有 workers 个 worker 的协程池，每个 worker 的队列长度是 queueSize 。
+func NewWorkerPool(workers int, queueSize int) *WorkerPool {
+	if workers < 1 {
+		workers = 1
+	}
+	p := &WorkerPool{
+		queues: make([]chan *poolTask, workers),
+	}
+	for i := range p.queues {
+		queue := make(chan *poolTask, queueSize)
+		p.queues[i] = queue
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for t := range queue {
+				runPoolTask(t)
+			}
+		}()
+	}
+	return p
+}
+
+// Dispatch 把 handler 派发给 key 对应的 worker 异步执行，执行时的参数是包裹 c 的 Context 。
+// 它的 Context.Context 不会因为当前处理函数返回而被取消，但仍会在连接中断时被取消。
+// handler 返回的错误（包括 panic）交给 c.Error 处理。
+// 队列满时阻塞，直到有空位、c.Context() 被取消或协程池停止。
+func (p *WorkerPool) Dispatch(c Context, key string, handler HandlerFunc) error {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	if p.stopped {
+		return PoolStopped
+	}
+	detached, cancel := detachContext(c)
+	t := &poolTask{
+		c:       detached,
+		handler: handler,
+		cancel:  cancel,
+	}
+	select {
+	case p.queues[p.index(key)] <- t:
+		return nil
+	case <-c.Context().Done():
+		cancel()
+		return c.Context().Err()
+	}
+}
+
+// Handler 返回一个把消息派发到协程池处理的 HandlerFunc 。可以直接用于 Server.Add 或 Server.SetDefaultHandler 。
+func (p *WorkerPool) Handler(key KeyFunc, handler HandlerFunc) HandlerFunc {
+	return func(c Context) error {
+		return p.Dispatch(c, key(c), handler)
+	}
+}
+
+// Stop 停止接受新任务，等待已经派发的任务都执行完后返回。
+func (p *WorkerPool) Stop() {
+	p.mutex.Lock()
+	if !p.stopped {
+		p.stopped = true
+		for _, queue := range p.queues {
+			close(queue)
+		}
+	}
+	p.mutex.Unlock()
+	p.wg.Wait()
+}
+
+func (p *WorkerPool) index(key string) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return int(h.Sum32() % uint32(len(p.queues)))
+}
+
+func runPoolTask(t *poolTask) {
+	defer t.cancel()
+	var err error
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				err = &PanicError{
+					ConnectionID: t.c.ConnectionID(),
+					Value:        r,
+					Stack:        debug.Stack(),
+				}
+			}
+		}()
+		err = t.handler(t.c)
+	}()
+	if err != nil {
+		t.c.Error(err)
+	}
+}
+
+// wrappedContext 用于在 detachedContext 里嵌入 Context ：字段名不能和 Context 方法同名。
+type wrappedContext = Context
+
+// detachedContext 是 detachContext 返回的 Context 。除了 Context 和 SetContext ，都交给被包裹的 Context 。
+type detachedContext struct {
+	wrappedContext
+	ctx context.Context
+}
+
+func (c *detachedContext) Context() context.Context {
+	return c.ctx
+}
+
+func (c *detachedContext) SetContext(ctx context.Context) {
+	c.ctx = ctx
+}
+
+// detachContext 返回包裹 c 的 Context ，它的 Context.Context 不随 c.Context() 取消，但随连接中断而取消。
+// c 可以是被中间件包裹过的 Context 。 c.Context() 携带的值（例如 Span ）会保留。
+func detachContext(c Context) (Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.WithoutCancel(c.Context()))
+	stop := func() bool { return false }
+	if connection := connectionContextOf(c.Context()); connection != nil {
+		stop = context.AfterFunc(connection, cancel)
+	}
+	return &detachedContext{wrappedContext: c, ctx: ctx}, func() {
+		stop()
+		cancel()
+	}
+}
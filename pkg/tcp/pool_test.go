@@ -0,0 +1,111 @@
+package tcp
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWorkerPool_Dispatch(t *testing.T) {
+	pool := NewWorkerPool(4, 1)
+	var mutex sync.Mutex
+	got := make(map[string][]int)
+	connCtx, cancelConn := context.WithCancel(context.Background())
+	defer cancelConn()
+	connCtx = withConnectionContext(connCtx)
+	for i := 0; i < 20; i++ {
+		i := i
+		key := []string{"a", "b", "c"}[i%3]
+		msgCtx, cancelMsg := context.WithCancel(connCtx)
+		c := &handleContext{connID: ConnectionID(key), ctx: msgCtx}
+		err := pool.Dispatch(c, key, func(c Context) error {
+			if c.Context().Err() != nil {
+				t.Errorf("task %d: context canceled together with the dispatching handler", i)
+			}
+			time.Sleep(time.Millisecond)
+			mutex.Lock()
+			defer mutex.Unlock()
+			got[key] = append(got[key], i)
+			return nil
+		})
+		cancelMsg() // 派发的处理函数返回
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	pool.Stop()
+	for key, indexes := range got {
+		for j := 1; j < len(indexes); j++ {
+			if indexes[j] < indexes[j-1] {
+				t.Errorf("key %s: out of order %v", key, indexes)
+			}
+		}
+	}
+	if err := pool.Dispatch(&handleContext{ctx: connCtx}, "a", nil); err != PoolStopped {
+		t.Errorf("dispatch after stop: got %v, want PoolStopped", err)
+	}
+}
+
+func TestWorkerPool_Dispatch_backpressure(t *testing.T) {
+	pool := NewWorkerPool(1, 1)
+	defer pool.Stop()
+	release := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &handleContext{ctx: withConnectionContext(ctx)}
+	block := func(c Context) error {
+		<-release
+		return nil
+	}
+	_ = pool.Dispatch(c, "k", block) // worker 正在执行
+	_ = pool.Dispatch(c, "k", block) // 队列满
+	// 第一个任务阻塞在 release 上，第二个占满了队列，所以这次派发只能等到 ctx 取消才返回
+	dispatched := make(chan error)
+	go func() { dispatched <- pool.Dispatch(c, "k", block) }()
+	cancel()
+	if err := <-dispatched; err != context.Canceled {
+		t.Errorf("got %v, want context.Canceled: dispatch did not block on a saturated pool", err)
+	}
+	close(release)
+}
+
+// routedContext 模拟中间件包裹的 Context 。
+type routedContext struct {
+	wrappedContext
+}
+
+func (c *routedContext) RouteName() string {
+	return "wrapped"
+}
+
+func TestWorkerPool_Dispatch_wrappedContext(t *testing.T) {
+	pool := NewWorkerPool(1, 1)
+	defer pool.Stop()
+	connCtx, cancelConn := context.WithCancel(context.Background())
+	defer cancelConn()
+	msgCtx, cancelMsg := context.WithCancel(withConnectionContext(connCtx))
+	running := make(chan Context)
+	release := make(chan struct{})
+	finished := make(chan struct{})
+	err := pool.Dispatch(&routedContext{&handleContext{ctx: msgCtx}}, "k", func(c Context) error {
+		defer close(finished)
+		running <- c
+		<-release
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := <-running
+	cancelMsg() // 派发的处理函数返回
+	if c.RouteName() != "wrapped" {
+		t.Errorf("task lost the wrapping context, route name %q", c.RouteName())
+	}
+	if c.Context().Err() != nil {
+		t.Error("context canceled together with the dispatching handler")
+	}
+	cancelConn()
+	<-c.Context().Done() // 连接中断时取消
+	close(release)
+	<-finished
+}
@@ -2,32 +2,105 @@ package tcp
 
 import (
 	"context"
+	"crypto/tls"
 	"github.com/pkg/errors"
+	"log/slog"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// ProcessingMode 决定一个连接收到的消息如何被处理。
+type ProcessingMode int
+
+const (
+	// Sequential 一次只处理一条消息，处理完才处理下一条。是默认值。
+	Sequential ProcessingMode = iota
+	// PipelinedOrdered 最多同时处理 Concurrency 条消息，但处理函数返回前发送的响应会按消息的收到顺序发送。
+	// 慢消息会让后续消息的响应等待，但不会让后续消息的处理等待。
+	PipelinedOrdered
+	// PipelinedUnordered 最多同时处理 Concurrency 条消息，响应按发送的先后顺序发送。
+	// 适用于消息里带有关联 ID 的协议。
+	PipelinedUnordered
 )
 
 // Processor 是一个由中间件堆砌起来的消息处理栈。
 type Processor struct {
-	connID                 ConnectionID
+	connection             *Connection
 	receivedMessageChannel <-chan ReceivedMessage
 	sendingMessageChannel  chan<- SendingMessage
-	handler                HandlerFunc
+	config                 *DaemonConfig
+	logger                 *slog.Logger
+	closeFunc              context.CancelCauseFunc // 关闭连接
+	session                *session
+	protocols              *protocolSelector
+	codecs                 *codecChain
 }
 
+// NewProcessor 创建一个用 handler 按 Sequential 模式处理消息的 Processor ，不支持握手、多协议和帧编解码。
+// 处理函数返回的错误交给 DefaultErrorHandler 。没有底层的连接，所以 Context.Close 不起作用， Context.RemoteAddr 返回 nil 。
+//
+// Deprecated: Processor 和同一个连接的 Receiver 、 Sender 共享状态，应该用 NewDaemon 创建。
 func NewProcessor(
 	connID ConnectionID,
 	receivedMessageChannel <-chan ReceivedMessage,
 	sendingMessageChannel chan<- SendingMessage,
 	handler HandlerFunc,
 ) *Processor {
+	config := &DaemonConfig{
+		Handler:      handler,
+		ErrorHandler: DefaultErrorHandler,
+		Metrics:      NopMetricsCollector{},
+		Tracer:       nopTracer{},
+	}
+	return newProcessor(&Connection{connectionID: connID}, newProtocolSelector(config), newCodecChain(connID, nil),
+		receivedMessageChannel, sendingMessageChannel, config, slog.Default(), func(error) {})
+}
+
+// newProcessor 由 Daemon 调用。protocols 和 codecs 和同一个连接的 Receiver 、 Sender 共享。
+func newProcessor(
+	connection *Connection,
+	protocols *protocolSelector,
+	codecs *codecChain,
+	receivedMessageChannel <-chan ReceivedMessage,
+	sendingMessageChannel chan<- SendingMessage,
+	config *DaemonConfig,
+	logger *slog.Logger,
+	closeFunc context.CancelCauseFunc,
+) *Processor {
+	if connection.session == nil {
+		connection.session = &session{}
+	}
+	if config.Handshake == nil {
+		connection.session.authenticate(nil)
+	}
 	return &Processor{
-		connID:                 connID,
+		connection:             connection,
 		receivedMessageChannel: receivedMessageChannel,
 		sendingMessageChannel:  sendingMessageChannel,
-		handler:                handler,
+		config:                 config,
+		logger:                 logger,
+		closeFunc:              closeFunc,
+		session:                connection.session,
+		protocols:              protocols,
+		codecs:                 codecs,
 	}
 }
 
+// KeepWorking 按 ProcessingMode 持续处理收到的消息，直到 ctx 结束或 channel 被关闭。
+// 配置了握手处理函数时，先完成握手再处理后续消息。
+// 返回前会等待所有正在处理的消息处理完。
 func (p *Processor) KeepWorking(ctx context.Context) error {
+	if err := p.keepHandshaking(ctx); err != nil {
+		return err
+	}
+	if p.config.ProcessingMode == Sequential {
+		return p.keepWorkingSequentially(ctx)
+	}
+	return p.keepWorkingConcurrently(ctx)
+}
+
+func (p *Processor) keepWorkingSequentially(ctx context.Context) error {
 	for {
 		select {
 		case <-ctx.Done():
@@ -36,14 +109,195 @@ func (p *Processor) KeepWorking(ctx context.Context) error {
 			if !ok {
 				return errors.New("channel is closed")
 			}
-			c := &handleContext{
-				connID:                p.connID,
-				received:              m,
-				sendingMessageChannel: p.sendingMessageChannel,
+			_ = p.process(p.newContext(ctx, m), p.protocols.handler())
+			p.finishSequentially(ctx)
+		}
+	}
+}
+
+// finishSequentially 在逐条处理的一条消息处理完之后，切换处理函数要求的协议和 FrameCodec ，然后通知 Receiver 拆分下一条消息。
+// 处理函数要求升级到 TLS 时，先等 Sender 发完之前的消息并暂停，再让 Receiver 完成升级。
+func (p *Processor) finishSequentially(ctx context.Context) {
+	if protocol := p.protocols.applyPending(); protocol != nil {
+		p.logger.Debug("tcp: protocol switched", "protocol", protocol.name)
+	}
+	p.codecs.applyPending()
+	var upgrade *tlsUpgrade
+	if config := p.protocols.takeTLS(); config != nil {
+		if pause, ok := p.pauseSender(ctx); ok {
+			upgrade = &tlsUpgrade{config: config, pause: pause}
+		}
+	}
+	p.protocols.release(upgrade)
+}
+
+// keepWorkingConcurrently 最多同时处理 Concurrency 条消息。
+// PipelinedOrdered 模式下，每条消息发送的响应先缓存起来，由 keepFlushing 按消息的收到顺序放入待发送消息队列。
+func (p *Processor) keepWorkingConcurrently(ctx context.Context) error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	concurrency := p.config.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	slots := make(chan struct{}, concurrency)
+	var sequence chan *orderedReplies
+	if p.config.ProcessingMode == PipelinedOrdered {
+		sequence = make(chan *orderedReplies, concurrency)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.keepFlushing(ctx, sequence)
+		}()
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return errors.New("context is done")
+		case m, ok := <-p.receivedMessageChannel:
+			if !ok {
+				return errors.New("channel is closed")
+			}
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				return errors.New("context is done")
+			}
+			c := p.newContext(ctx, m)
+			handler := p.protocols.handler()
+			if sequence != nil {
+				c.replies = newOrderedReplies()
+				select {
+				case sequence <- c.replies:
+				case <-ctx.Done():
+					return errors.New("context is done")
+				}
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-slots }()
+				_ = p.process(c, handler)
+				if c.replies != nil {
+					c.replies.finish()
+				}
+			}()
+		}
+	}
+}
+
+// keepFlushing 按顺序等待每条消息处理完，把它缓存的响应放入待发送消息队列。
+func (p *Processor) keepFlushing(ctx context.Context, sequence <-chan *orderedReplies) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case replies := <-sequence:
+			select {
+			case <-replies.done:
+			case <-ctx.Done():
+				return
 			}
-			if err := p.handler(c); err != nil {
-				// fmt.Println("handle failed", err)
+			for _, m := range replies.messages {
+				p.config.Metrics.SendQueueDepth(p.connection.stats.enqueue())
+				select {
+				case p.sendingMessageChannel <- m:
+				case <-ctx.Done():
+					p.connection.stats.dequeue()
+					p.config.Metrics.MessageDropped()
+					return
+				}
 			}
 		}
 	}
 }
+
+func (p *Processor) newContext(ctx context.Context, m ReceivedMessage) *handleContext {
+	var tlsState *tls.ConnectionState
+	if tlsConn, ok := p.connection.conn.(*tls.Conn); ok {
+		state := tlsConn.ConnectionState()
+		tlsState = &state
+	}
+	return &handleContext{
+		connID:                p.connection.connectionID,
+		remoteAddr:            p.connection.RemoteAddr(),
+		received:              m,
+		ctx:                   withConnectionContext(ctx),
+		sendingMessageChannel: p.sendingMessageChannel,
+		stats:                 &p.connection.stats,
+		done:                  ctx.Done(),
+		errorHandler:          p.config.ErrorHandler,
+		closeFunc:             func() { p.closeFunc(ConnectionClosedByHandler) },
+		logger:                p.logger,
+		metrics:               p.config.Metrics,
+		session:               p.session,
+		protocols:             p.protocols,
+		codecs:                p.codecs,
+		tlsState:              tlsState,
+	}
+}
+
+// process 用 handler 处理一条消息。处理出错（包括 panic）时交给 ErrorHandlerFunc ，并返回这个错误。
+// c.Context() 在处理函数返回后会被取消。
+func (p *Processor) process(c Context, handler HandlerFunc) error {
+	ctx, cancel := p.messageContext(c.Context())
+	defer cancel()
+	ctx, span := p.config.Tracer.Start(ctx, MessageSpanName)
+	c.SetContext(ctx)
+	span.SetAttribute(SpanAttributeConnectionID, string(p.connection.connectionID))
+	if addr := p.connection.RemoteAddr(); addr != nil {
+		span.SetAttribute(SpanAttributeRemoteAddr, addr.String())
+	}
+	if m, ok := c.Received().(Serializable); ok {
+		span.SetAttribute(SpanAttributeFrameSize, len(m.Bytes()))
+	}
+	start := time.Now()
+	err := p.handle(c, handler)
+	p.config.Metrics.HandlerDuration(c.RouteName(), time.Since(start))
+	span.SetAttribute(SpanAttributeRoute, c.RouteName())
+	span.SetAttribute(SpanAttributeOutcome, outcome(err))
+	span.End(err)
+	if err != nil {
+		c.Error(err)
+		var panicError *PanicError
+		if errors.As(err, &panicError) && p.config.PanicPolicy == PanicClose {
+			c.Close()
+		}
+	}
+	return err
+}
+
+// messageContext 从连接的 ctx 派生出一条消息专用的 context.Context 。配置了 HandlerTimeout 时带有截止时间。
+func (p *Processor) messageContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if p.config.HandlerTimeout > 0 {
+		return context.WithTimeout(ctx, p.config.HandlerTimeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// handle 调用处理函数，并把其中的 panic 转换成 *PanicError ，避免一条消息导致整个进程崩溃。
+func (p *Processor) handle(c Context, handler HandlerFunc) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PanicError{
+				ConnectionID: p.connection.connectionID,
+				Value:        r,
+				Stack:        debug.Stack(),
+			}
+		}
+	}()
+	return handler(c)
+}
+
+// outcome 把处理结果归类成 SpanAttributeOutcome 的取值。
+func outcome(err error) string {
+	var panicError *PanicError
+	switch {
+	case err == nil:
+		return "ok"
+	case errors.As(err, &panicError):
+		return "panic"
+	default:
+		return "error"
+	}
+}
@@ -0,0 +1,224 @@
+package tcp
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+)
+
+func newTestProcessor(t *testing.T, config *DaemonConfig, received <-chan ReceivedMessage, sending chan<- SendingMessage) *Processor {
+	client, server := net.Pipe()
+	t.Cleanup(func() {
+		_ = client.Close()
+		_ = server.Close()
+	})
+	if config.Metrics == nil {
+		config.Metrics = NopMetricsCollector{}
+	}
+	if config.Tracer == nil {
+		config.Tracer = nopTracer{}
+	}
+	return newProcessor(&Connection{connectionID: "conn1", conn: server}, newProtocolSelector(config), newCodecChain("conn1", config.Codecs), received, sending, config, slog.Default(), nil)
+}
+
+func TestProcessor_process_panic(t *testing.T) {
+	for _, policy := range []PanicPolicy{PanicContinue, PanicClose} {
+		var handled error
+		closed := false
+		config := &DaemonConfig{
+			Handler: func(c Context) error {
+				_ = c.Received().(string) // 类型断言失败导致 panic
+				return nil
+			},
+			ErrorHandler: func(c Context, err error) {
+				handled = err
+			},
+			PanicPolicy: policy,
+		}
+		p := newTestProcessor(t, config, nil, nil)
+		_ = p.process(&handleContext{
+			connID:       "conn1",
+			received:     NewPacket([]byte("x")),
+			errorHandler: config.ErrorHandler,
+			closeFunc:    func() { closed = true },
+			ctx:          context.Background(),
+		}, config.Handler)
+		var panicError *PanicError
+		if !errors.As(handled, &panicError) {
+			t.Fatalf("policy %d: error handler got %v, want *PanicError", policy, handled)
+		}
+		if panicError.ConnectionID != "conn1" || len(panicError.Stack) == 0 {
+			t.Errorf("policy %d: bad panic error %+v", policy, panicError)
+		}
+		if closed != (policy == PanicClose) {
+			t.Errorf("policy %d: closed=%v", policy, closed)
+		}
+	}
+}
+
+type spanKey struct{}
+
+type recordedSpan struct {
+	attributes map[string]interface{}
+	err        error
+	ended      bool
+}
+
+func (s *recordedSpan) SetAttribute(key string, value interface{}) {
+	s.attributes[key] = value
+}
+
+func (s *recordedSpan) End(err error) {
+	s.err = err
+	s.ended = true
+}
+
+type recordingTracer struct {
+	spans []*recordedSpan
+}
+
+func (t *recordingTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	span := &recordedSpan{attributes: map[string]interface{}{}}
+	t.spans = append(t.spans, span)
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+func TestProcessor_process_tracing(t *testing.T) {
+	tracer := &recordingTracer{}
+	var spanInHandler interface{}
+	p := newTestProcessor(t, &DaemonConfig{
+		Handler: func(c Context) error {
+			spanInHandler = c.Context().Value(spanKey{})
+			c.SetRouteName("echo")
+			return UnknownMessage
+		},
+		ErrorHandler: func(c Context, err error) {},
+		Tracer:       tracer,
+	}, nil, nil)
+	_ = p.process(&handleContext{
+		connID:       "conn1",
+		received:     NewPacket([]byte("hello")),
+		errorHandler: p.config.ErrorHandler,
+		ctx:          context.Background(),
+	}, p.config.Handler)
+	if len(tracer.spans) != 1 {
+		t.Fatalf("got %d spans, want 1", len(tracer.spans))
+	}
+	span := tracer.spans[0]
+	if spanInHandler != span {
+		t.Error("span is not carried by Context.Context")
+	}
+	if !span.ended || span.err != UnknownMessage {
+		t.Errorf("span ended=%v err=%v", span.ended, span.err)
+	}
+	for key, want := range map[string]interface{}{
+		SpanAttributeConnectionID: "conn1",
+		SpanAttributeFrameSize:    5,
+		SpanAttributeRoute:        "echo",
+		SpanAttributeOutcome:      "error",
+	} {
+		if span.attributes[key] != want {
+			t.Errorf("attribute %s = %v, want %v", key, span.attributes[key], want)
+		}
+	}
+}
+
+func TestProcessor_process_timeout(t *testing.T) {
+	var handlerErr error
+	p := newTestProcessor(t, &DaemonConfig{
+		Handler: func(c Context) error {
+			<-c.Context().Done()
+			return c.Context().Err()
+		},
+		ErrorHandler: func(c Context, err error) {
+			handlerErr = err
+		},
+		HandlerTimeout: time.Millisecond * 10,
+	}, nil, nil)
+	c := &handleContext{
+		connID:       "conn1",
+		received:     NewPacket([]byte("slow")),
+		errorHandler: p.config.ErrorHandler,
+		ctx:          context.Background(),
+	}
+	_ = p.process(c, p.config.Handler)
+	if !errors.Is(handlerErr, context.DeadlineExceeded) {
+		t.Errorf("handler got %v, want context.DeadlineExceeded", handlerErr)
+	}
+	if c.Context().Err() == nil {
+		t.Error("message context is not canceled after the handler returned")
+	}
+}
+
+func TestProcessor_KeepWorking_pipelined(t *testing.T) {
+	for mode, want := range map[ProcessingMode]string{
+		PipelinedOrdered:   "312",
+		PipelinedUnordered: "123",
+	} {
+		received := make(chan ReceivedMessage)
+		sending := make(chan SendingMessage, 3)
+		// 每条消息的 handler 等到对应的 release 被关闭才返回，按 1 、 2 、 3 的顺序放行。
+		release := map[string]chan struct{}{"1": make(chan struct{}), "2": make(chan struct{}), "3": make(chan struct{})}
+		finished := make(chan struct{})
+		p := newTestProcessor(t, &DaemonConfig{
+			Handler: func(c Context) error {
+				word := c.Received().(*Packet).Bytes()
+				<-release[string(word)]
+				c.Send(NewPacket(word))
+				finished <- struct{}{}
+				return nil
+			},
+			ProcessingMode: mode,
+			Concurrency:    3,
+		}, received, sending)
+		ctx, cancel := context.WithCancel(context.Background())
+		exited := make(chan struct{})
+		go func() {
+			_ = p.KeepWorking(ctx)
+			close(exited)
+		}()
+		for _, word := range []string{"3", "1", "2"} {
+			received <- NewPacket([]byte(word))
+		}
+		for _, word := range []string{"1", "2", "3"} {
+			close(release[word])
+			<-finished
+		}
+		got := ""
+		for i := 0; i < 3; i++ {
+			got += string((<-sending).Bytes())
+		}
+		cancel()
+		<-exited
+		if got != want {
+			t.Errorf("mode %d: got %q, want %q", mode, got, want)
+		}
+	}
+}
+
+func TestNewProcessor(t *testing.T) {
+	received := make(chan ReceivedMessage)
+	sending := make(chan SendingMessage, 1)
+	p := NewProcessor("conn1", received, sending, func(c Context) error {
+		if c.RemoteAddr() != nil {
+			t.Errorf("remote address %v, want nil", c.RemoteAddr())
+		}
+		c.Send(NewPacket(append([]byte("re:"), c.Received().(*Packet).Bytes()...)))
+		return nil
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	exited := make(chan struct{})
+	go func() {
+		_ = p.KeepWorking(ctx)
+		close(exited)
+	}()
+	received <- NewPacket([]byte("ping"))
+	if reply := <-sending; string(reply.Bytes()) != "re:ping" {
+		t.Errorf("got %q", reply.Bytes())
+	}
+	cancel()
+	<-exited
+}
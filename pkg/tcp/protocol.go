@@ -0,0 +1,216 @@
+package tcp
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"github.com/pkg/errors"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultProtocolName 是 Server 本身（SetSplitter 、 Use 、 Add 等配置的）协议的名字。
+const DefaultProtocolName = "default"
+
+// SniffFunc 检查连接最开始收到的字节，判断连接是否使用某个协议。
+// 数据不够判断时返回 NoEnoughData ，返回其他错误会关闭连接。buf 至少有1个字节。
+type SniffFunc func(buf []byte) (bool, error)
+
+// SniffPrefix 返回一个 SniffFunc ，连接以 prefix 开始时匹配。
+func SniffPrefix(prefix []byte) SniffFunc {
+	return func(buf []byte) (bool, error) {
+		if len(buf) < len(prefix) {
+			if !bytes.HasPrefix(prefix, buf) {
+				return false, nil
+			}
+			return false, NoEnoughData
+		}
+		return bytes.HasPrefix(buf, prefix), nil
+	}
+}
+
+// Protocol 是同一个端口上的一种协议，有自己的分包器、中间件、路由规则和默认处理函数。
+// 用 Server.AddProtocol 注册，用 Use 、 Add 、 Group 配置处理栈。错误处理函数和握手处理函数与 Server 共享。
+type Protocol struct {
+	*Group
+	name           string
+	sniff          SniffFunc
+	splitter       SplitterFunc
+	defaultHandler HandlerFunc
+	handler        HandlerFunc // build 之后的处理函数
+}
+
+func (p *Protocol) Name() string {
+	return p.name
+}
+
+// SetDefaultHandler 设置本协议的默认处理函数。没有设置时使用 DefaultHandler 。
+func (p *Protocol) SetDefaultHandler(handler HandlerFunc) {
+	p.defaultHandler = handler
+}
+
+// build 把中间件、路由规则、默认处理函数组装成 p.handler 。
+func (p *Protocol) build() {
+	defaultHandler := p.defaultHandler
+	if defaultHandler == nil {
+		defaultHandler = DefaultHandler
+	}
+	p.handler = applyMiddleware(buildRouter(p.routers, defaultHandler), p.middleware)
+}
+
+// protocolSelector 记录一个连接当前使用的协议，由 Receiver 和 Processor 共享。
+// Sequential 模式下，如果注册了其他协议或 FrameCodec ，或者允许 STARTTLS ，Receiver 每次把消息交给 Processor 之后等待它处理完，
+// 再用当前的协议拆分下一条消息，所以处理函数里调用的 Context.SwitchProtocol 、 Context.StartTLS 和 Context.SetCodecEnabled 从紧接着的字节开始生效。
+// 都没有时 Receiver 不等待，处理一条消息的同时可以收取和拆分下一条。
+type protocolSelector struct {
+	protocols  []*Protocol // 按注册顺序嗅探
+	fallback   *Protocol   // 都不匹配时使用，即 Server 本身
+	current    atomic.Pointer[Protocol]
+	mutex      sync.Mutex
+	pending    *Protocol        // 处理函数要求切换到的协议，处理函数返回后生效
+	tlsConfig  *tls.Config      // 处理函数要求升级到 TLS ，处理函数返回后生效
+	ready      chan *tlsUpgrade // Processor 处理完一条消息后通知 Receiver ，需要时带上 TLS 升级。Receiver 不需要等待时为 nil
+	tlsEnabled bool             // 是否允许 Context.StartTLS
+}
+
+func newProtocolSelector(config *DaemonConfig) *protocolSelector {
+	s := &protocolSelector{
+		protocols: config.Protocols,
+		fallback: &Protocol{
+			name:     DefaultProtocolName,
+			splitter: config.Splitter,
+			handler:  config.Handler,
+		},
+	}
+	s.tlsEnabled = config.StartTLS
+	if config.ProcessingMode == Sequential && (len(config.Protocols) > 0 || len(config.Codecs) > 0 || config.StartTLS) {
+		s.ready = make(chan *tlsUpgrade, 1)
+	}
+	sniffing := false
+	for _, p := range s.protocols {
+		sniffing = sniffing || p.sniff != nil
+	}
+	if !sniffing {
+		s.current.Store(s.fallback)
+	}
+	return s
+}
+
+// selected 返回当前的协议。还没有嗅探出协议时返回 nil 。
+func (s *protocolSelector) selected() *Protocol {
+	return s.current.Load()
+}
+
+// handler 返回当前协议的处理函数。
+func (s *protocolSelector) handler() HandlerFunc {
+	if p := s.selected(); p != nil {
+		return p.handler
+	}
+	return s.fallback.handler
+}
+
+// sniff 按注册顺序用 buf 嗅探协议，第一个匹配的生效。都不匹配时使用 fallback 。
+// 还没有收到任何字节时返回 NoEnoughData ，不调用 SniffFunc ，所以 SniffFunc 不需要自己处理空的 buf 。
+func (s *protocolSelector) sniff(buf []byte) (*Protocol, error) {
+	if len(buf) == 0 {
+		return nil, NoEnoughData
+	}
+	for _, p := range s.protocols {
+		if p.sniff == nil {
+			continue
+		}
+		if ok, err := p.sniff(buf); err != nil {
+			return nil, err
+		} else if ok {
+			s.current.Store(p)
+			return p, nil
+		}
+	}
+	s.current.Store(s.fallback)
+	return s.fallback, nil
+}
+
+// find 按名字查找协议。
+func (s *protocolSelector) find(name string) *Protocol {
+	if name == DefaultProtocolName {
+		return s.fallback
+	}
+	for _, p := range s.protocols {
+		if p.name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// switchTo 要求处理函数返回后切换到 name 协议。
+func (s *protocolSelector) switchTo(name string) error {
+	if s.ready == nil {
+		return errors.Wrap(ProtocolSwitchNotSupported, "not in Sequential mode or no protocol added")
+	}
+	p := s.find(name)
+	if p == nil {
+		return errors.Wrap(UnknownProtocol, name)
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.pending = p
+	return nil
+}
+
+// applyPending 切换到 switchTo 要求的协议。没有要求时返回 nil 。
+func (s *protocolSelector) applyPending() *Protocol {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	p := s.pending
+	if p != nil {
+		s.current.Store(p)
+		s.pending = nil
+	}
+	return p
+}
+
+// startTLS 要求处理函数返回后升级到 TLS 。
+func (s *protocolSelector) startTLS(config *tls.Config) error {
+	if !s.tlsEnabled {
+		return errors.Wrap(StartTLSNotSupported, "not enabled, see Server.SetStartTLSEnabled")
+	}
+	if s.ready == nil {
+		return errors.Wrap(StartTLSNotSupported, "not in Sequential mode")
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.tlsConfig = config
+	return nil
+}
+
+// takeTLS 返回 startTLS 要求的 TLS 配置。没有要求时返回 nil 。
+func (s *protocolSelector) takeTLS() *tls.Config {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	config := s.tlsConfig
+	s.tlsConfig = nil
+	return config
+}
+
+// release 通知 Receiver 可以拆分下一条消息了。upgrade 不是 nil 时，Receiver 要先完成 TLS 升级。
+// Receiver 每交出一条消息都会等待一次通知，所以 ready 里最多只有一个通知，这里不会阻塞。
+func (s *protocolSelector) release(upgrade *tlsUpgrade) {
+	select {
+	case s.ready <- upgrade:
+	default:
+	}
+}
+
+// wait 等待 Processor 处理完刚刚交给它的消息，返回需要完成的 TLS 升级。ctx 结束时返回 false 。
+func (s *protocolSelector) wait(ctx context.Context) (*tlsUpgrade, bool) {
+	if s.ready == nil {
+		return nil, true
+	}
+	select {
+	case upgrade := <-s.ready:
+		return upgrade, true
+	case <-ctx.Done():
+		return nil, false
+	}
+}
@@ -0,0 +1,151 @@
+package tcp
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+)
+
+func lineSplitter(buf []byte) (*Packet, int, error) {
+	if i := bytes.IndexByte(buf, '\n'); i >= 0 {
+		return NewPacket(buf[:i]), i + 1, nil
+	}
+	return nil, 0, NoEnoughData
+}
+
+func TestServer_AddProtocol(t *testing.T) {
+	s := NewServer()
+	s.SetLogHandler(slog.NewTextHandler(io.Discard, nil))
+	s.SetDefaultHandler(func(c Context) error {
+		c.Send(NewPacket([]byte(c.Protocol() + ":" + string(c.Received().(*Packet).Bytes()) + "\n")))
+		return nil
+	})
+	line := s.AddProtocol("line", SniffPrefix([]byte("HELLO")), lineSplitter)
+	line.Add(func(m ReceivedMessage) bool {
+		return string(m.(*Packet).Bytes()) == "UPGRADE"
+	}, func(c Context) error {
+		c.Send(NewPacket([]byte("upgrading\n")))
+		return c.SwitchProtocol(DefaultProtocolName)
+	})
+	line.SetDefaultHandler(func(c Context) error {
+		c.Send(NewPacket([]byte(c.Protocol() + ":" + string(c.Received().(*Packet).Bytes()) + "\n")))
+		return nil
+	})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() { done <- s.Serve(ln) }()
+	defer func() {
+		_ = s.Stop()
+		<-done
+	}()
+
+	for _, c := range []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{"sniffed", "HELLO\nabc\n", []string{"line:HELLO", "line:abc"}},
+		{"fallback", "xyz", []string{"default:xyz"}},
+		// UPGRADE 之后的字节和它在同一次写入里，也要用新协议的分包器拆分
+		{"switched", "HELLO\nUPGRADE\nraw\nbytes", []string{"line:HELLO", "upgrading", "default:raw", "bytes"}},
+	} {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = conn.SetDeadline(time.Now().Add(time.Second))
+		if _, err := conn.Write([]byte(c.input)); err != nil {
+			t.Fatal(err)
+		}
+		r := bufio.NewReader(conn)
+		var got []string
+		for len(got) < len(c.want) {
+			if reply, err := r.ReadString('\n'); err != nil {
+				t.Errorf("%s: %v", c.name, err)
+				break
+			} else {
+				got = append(got, reply[:len(reply)-1])
+			}
+		}
+		for i := range got {
+			if i >= len(c.want) || got[i] != c.want[i] {
+				t.Errorf("%s: got %q, want %q", c.name, got, c.want)
+				break
+			}
+		}
+		_ = conn.Close()
+	}
+}
+
+func TestServer_AddProtocol_sniffAfterFirstRead(t *testing.T) {
+	// 这个 SniffFunc 对空的 buf 返回 false 而不是 NoEnoughData ，嗅探仍然要等收到数据之后。
+	// Receiver 开始时 buf 总是空的，所以不需要等它先运行。
+	client, server := net.Pipe()
+	defer client.Close()
+	s := NewServer()
+	s.SetLogHandler(slog.NewTextHandler(io.Discard, nil))
+	line := s.AddProtocol("line", func(buf []byte) (bool, error) {
+		if len(buf) == 0 {
+			t.Error("sniffed an empty buffer")
+		}
+		return bytes.HasPrefix(buf, []byte("HI")), nil
+	}, lineSplitter)
+	line.SetDefaultHandler(func(c Context) error {
+		c.Send(NewPacket([]byte(c.Protocol() + "\n")))
+		return nil
+	})
+	done := make(chan error)
+	go func() { done <- s.Serve(newPipeListener(server)) }()
+	defer func() {
+		_ = s.Stop()
+		<-done
+	}()
+	_ = client.SetDeadline(time.Now().Add(time.Second))
+	if _, err := client.Write([]byte("HI\n")); err != nil {
+		t.Fatal(err)
+	}
+	if reply, err := bufio.NewReader(client).ReadString('\n'); err != nil || reply != "line\n" {
+		t.Errorf("got %q, %v", reply, err)
+	}
+}
+
+func TestServer_sequentialReadsAhead(t *testing.T) {
+	// 没有注册其他协议和 FrameCodec ，也没有开启 STARTTLS 时，处理一条消息的同时 Receiver 继续收取下一条
+	client, server := net.Pipe()
+	defer client.Close()
+	started := make(chan struct{})
+	release := make(chan struct{})
+	s := NewServer()
+	s.SetLogHandler(slog.NewTextHandler(io.Discard, nil))
+	s.SetSplitter(lineSplitter)
+	s.SetDefaultHandler(func(c Context) error {
+		if string(c.Received().(*Packet).Bytes()) == "slow" {
+			close(started)
+			<-release
+		}
+		return nil
+	})
+	done := make(chan error)
+	go func() { done <- s.Serve(newPipeListener(server)) }()
+	defer func() {
+		_ = s.Stop()
+		<-done
+	}()
+	defer close(release)
+	_ = client.SetDeadline(time.Now().Add(time.Second))
+	if _, err := client.Write([]byte("slow\n")); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	// net.Pipe 的写入要等对端读取才返回，所以处理函数返回前这次写入就能完成，说明 Receiver 没有等待
+	if _, err := client.Write([]byte("next\n")); err != nil {
+		t.Fatalf("receiver waited for the handler: %v", err)
+	}
+}
@@ -0,0 +1,237 @@
+package tcp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BadProxyHeader 是 PROXY protocol 头格式错误时返回的错误。
+var BadProxyHeader = errors.New("bad proxy protocol header")
+
+// ProxyProtocolMode 决定是否解析 PROXY protocol 头。
+// 参考 https://www.haproxy.org/download/2.8/doc/proxy-protocol.txt
+//
+// 规范要求接收方不能猜测连接有没有头：猜测时任何直连的客户端都可以伪造头里的地址，绕过按地址做的准入（例如 IPFilter ）。
+// 所以只有关闭和必须两种模式。开启后，监听的端口只应该让负载均衡访问。
+type ProxyProtocolMode int
+
+const (
+	// ProxyProtocolOff 不解析。是默认值。
+	ProxyProtocolOff ProxyProtocolMode = iota
+	// ProxyProtocolRequired 连接必须以 PROXY protocol 头开始，否则拒绝。
+	ProxyProtocolRequired
+)
+
+// ProxyHeaderTimeout 是读取 PROXY protocol 头的时限。
+const ProxyHeaderTimeout = time.Second * 5
+
+// 连接被拒绝的原因：PROXY protocol 头错误。
+const RejectReasonProxyProtocol = "proxy_protocol"
+
+var (
+	proxyV1Signature = []byte("PROXY ")
+	proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+// ProxyHeader 是解析出的 PROXY protocol 头。
+type ProxyHeader struct {
+	Version         int      // 1 或 2
+	SourceAddr      net.Addr // 真实的客户端地址。LOCAL 命令或 UNKNOWN 协议时为 nil
+	DestinationAddr net.Addr // 客户端连接的代理地址。LOCAL 命令或 UNKNOWN 协议时为 nil
+}
+
+// NewProxyProtocolListener 包裹 ln ，它接受的连接会先解析 PROXY protocol 头，
+// 之后 RemoteAddr 和 LocalAddr 返回头里的地址，Read 从头之后的字节开始。
+// 解析发生在第一次调用 Read 、 RemoteAddr 或 LocalAddr 时，不会阻塞 Accept 。
+// 需要 TLS 时，应该用 tls.NewListener 包裹它返回的 net.Listener 。
+func NewProxyProtocolListener(ln net.Listener, mode ProxyProtocolMode) net.Listener {
+	return &proxyProtocolListener{Listener: ln, mode: mode}
+}
+
+type proxyProtocolListener struct {
+	net.Listener
+	mode ProxyProtocolMode
+}
+
+func (l *proxyProtocolListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil || l.mode == ProxyProtocolOff {
+		return conn, err
+	}
+	return &proxyConn{Conn: conn, reader: bufio.NewReader(conn)}, nil
+}
+
+// proxyConn 是带有 PROXY protocol 头的连接。
+type proxyConn struct {
+	net.Conn
+	reader *bufio.Reader
+	once   sync.Once
+	header *ProxyHeader
+	err    error
+}
+
+// Header 解析并返回 PROXY protocol 头。
+func (c *proxyConn) Header() (*ProxyHeader, error) {
+	c.once.Do(func() {
+		if err := c.Conn.SetReadDeadline(time.Now().Add(ProxyHeaderTimeout)); err != nil {
+			c.err = err
+			return
+		}
+		c.header, c.err = readProxyHeader(c.reader)
+		if err := c.Conn.SetReadDeadline(time.Time{}); err != nil && c.err == nil {
+			c.err = err
+		}
+	})
+	return c.header, c.err
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	if _, err := c.Header(); err != nil {
+		return 0, err
+	}
+	return c.reader.Read(b)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	if h, err := c.Header(); err == nil && h != nil && h.SourceAddr != nil {
+		return h.SourceAddr
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *proxyConn) LocalAddr() net.Addr {
+	if h, err := c.Header(); err == nil && h != nil && h.DestinationAddr != nil {
+		return h.DestinationAddr
+	}
+	return c.Conn.LocalAddr()
+}
+
+// readProxyHeader 从 r 读取一个 v1 或 v2 的 PROXY protocol 头。r 不是以 PROXY protocol 头开始时返回 BadProxyHeader 。
+func readProxyHeader(r *bufio.Reader) (*ProxyHeader, error) {
+	if ok, err := hasPrefix(r, proxyV1Signature); err != nil {
+		return nil, err
+	} else if ok {
+		return readProxyHeaderV1(r)
+	}
+	if ok, err := hasPrefix(r, proxyV2Signature); err != nil {
+		return nil, err
+	} else if ok {
+		return readProxyHeaderV2(r)
+	}
+	return nil, errors.Wrap(BadProxyHeader, "missing signature")
+}
+
+// hasPrefix 逐字节检查 r 是否以 prefix 开始，不消耗字节。一旦不匹配就返回，不会等待更多数据。
+func hasPrefix(r *bufio.Reader, prefix []byte) (bool, error) {
+	for i := 1; i <= len(prefix); i++ {
+		buf, err := r.Peek(i)
+		if err != nil {
+			return false, err
+		}
+		if buf[i-1] != prefix[i-1] {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// readProxyHeaderV1 解析文本格式，例如 "PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n" 。
+func readProxyHeaderV1(r *bufio.Reader) (*ProxyHeader, error) {
+	const maxLength = 107
+	line := make([]byte, 0, maxLength)
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+		if len(line) >= maxLength {
+			return nil, errors.Wrap(BadProxyHeader, "v1 header too long")
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errors.Wrap(BadProxyHeader, "v1 header without CRLF")
+	}
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	h := &ProxyHeader{Version: 1}
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return h, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, errors.Wrapf(BadProxyHeader, "v1 header %q", line)
+	}
+	src, err := parseProxyV1Addr(fields[2], fields[4])
+	if err != nil {
+		return nil, err
+	}
+	dst, err := parseProxyV1Addr(fields[3], fields[5])
+	if err != nil {
+		return nil, err
+	}
+	h.SourceAddr, h.DestinationAddr = src, dst
+	return h, nil
+}
+
+func parseProxyV1Addr(ip string, port string) (*net.TCPAddr, error) {
+	parsedIP := net.ParseIP(ip)
+	if parsedIP == nil {
+		return nil, errors.Wrapf(BadProxyHeader, "v1 ip %q", ip)
+	}
+	parsedPort, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, errors.Wrapf(BadProxyHeader, "v1 port %q", port)
+	}
+	return &net.TCPAddr{IP: parsedIP, Port: int(parsedPort)}, nil
+}
+
+// readProxyHeaderV2 解析二进制格式：12字节签名、版本和命令、地址族和协议、2字节长度、地址、TLV 。
+func readProxyHeaderV2(r *bufio.Reader) (*ProxyHeader, error) {
+	var fixed [16]byte
+	if _, err := io.ReadFull(r, fixed[:]); err != nil {
+		return nil, err
+	}
+	if fixed[12]>>4 != 2 {
+		return nil, errors.Wrapf(BadProxyHeader, "v2 version %d", fixed[12]>>4)
+	}
+	command := fixed[12] & 0x0f
+	family := fixed[13]
+	body := make([]byte, binary.BigEndian.Uint16(fixed[14:16]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	h := &ProxyHeader{Version: 2}
+	switch command {
+	case 0x0: // LOCAL ，例如代理自己的健康检查，使用真实的连接地址
+		return h, nil
+	case 0x1: // PROXY
+	default:
+		return nil, errors.Wrapf(BadProxyHeader, "v2 command %d", command)
+	}
+	switch family {
+	case 0x11, 0x12: // TCP/UDP over IPv4
+		if len(body) < 12 {
+			return nil, errors.Wrap(BadProxyHeader, "v2 ipv4 address too short")
+		}
+		h.SourceAddr = &net.TCPAddr{IP: net.IP(body[0:4]), Port: int(binary.BigEndian.Uint16(body[8:10]))}
+		h.DestinationAddr = &net.TCPAddr{IP: net.IP(body[4:8]), Port: int(binary.BigEndian.Uint16(body[10:12]))}
+	case 0x21, 0x22: // TCP/UDP over IPv6
+		if len(body) < 36 {
+			return nil, errors.Wrap(BadProxyHeader, "v2 ipv6 address too short")
+		}
+		h.SourceAddr = &net.TCPAddr{IP: net.IP(body[0:16]), Port: int(binary.BigEndian.Uint16(body[32:34]))}
+		h.DestinationAddr = &net.TCPAddr{IP: net.IP(body[16:32]), Port: int(binary.BigEndian.Uint16(body[34:36]))}
+	default: // UNSPEC 或 UNIX ，使用真实的连接地址
+	}
+	return h, nil
+}
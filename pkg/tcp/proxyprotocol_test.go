@@ -0,0 +1,101 @@
+package tcp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+)
+
+func proxyV2Header(command byte, family byte, addr []byte) []byte {
+	buf := append([]byte{}, proxyV2Signature...)
+	buf = append(buf, 0x20|command, family)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(addr)))
+	return append(buf, addr...)
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	ipv4 := []byte{192, 168, 0, 1, 10, 0, 0, 1, 0xdc, 0x04, 0x01, 0xbb}
+	ipv6 := append(net.ParseIP("2001:db8::1").To16(), net.ParseIP("2001:db8::2").To16()...)
+	ipv6 = append(ipv6, 0x00, 0x50, 0x01, 0xbb)
+	for _, c := range []struct {
+		name    string
+		input   []byte
+		source  string // 空字符串表示没有源地址
+		version int
+		bad     bool
+	}{
+		{"v1 tcp4", []byte("PROXY TCP4 192.168.0.1 10.0.0.1 56324 443\r\n"), "192.168.0.1:56324", 1, false},
+		{"v1 tcp6", []byte("PROXY TCP6 2001:db8::1 2001:db8::2 80 443\r\n"), "[2001:db8::1]:80", 1, false},
+		{"v1 unknown", []byte("PROXY UNKNOWN\r\n"), "", 1, false},
+		{"v1 bad port", []byte("PROXY TCP4 192.168.0.1 10.0.0.1 99999 443\r\n"), "", 0, true},
+		{"v1 no crlf", []byte("PROXY TCP4 192.168.0.1 10.0.0.1 1 443\n"), "", 0, true},
+		{"v2 ipv4", proxyV2Header(1, 0x11, ipv4), "192.168.0.1:56324", 2, false},
+		{"v2 ipv6", proxyV2Header(1, 0x21, ipv6), "[2001:db8::1]:80", 2, false},
+		{"v2 local", proxyV2Header(0, 0x00, nil), "", 2, false},
+		{"v2 short", proxyV2Header(1, 0x11, ipv4[:8]), "", 0, true},
+		{"missing", []byte("hello"), "", 0, true},
+	} {
+		r := bufio.NewReader(bytes.NewReader(append(c.input, "payload"...)))
+		h, err := readProxyHeader(r)
+		if c.bad {
+			if !errors.Is(err, BadProxyHeader) {
+				t.Errorf("%s: got error %v, want BadProxyHeader", c.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if h.Version != c.version {
+			t.Errorf("%s: version %d", c.name, h.Version)
+		} else if (h.SourceAddr == nil && c.source != "") || (h.SourceAddr != nil && h.SourceAddr.String() != c.source) {
+			t.Errorf("%s: source %v, want %q", c.name, h.SourceAddr, c.source)
+		}
+		// 头之后的字节原样保留
+		if rest, _ := io.ReadAll(r); string(rest) != "payload" {
+			t.Errorf("%s: rest %q", c.name, rest)
+		}
+	}
+}
+
+func TestServer_SetProxyProtocol(t *testing.T) {
+	remoteAddr := make(chan string, 1)
+	s := NewServer()
+	s.SetLogHandler(slog.NewTextHandler(io.Discard, nil))
+	s.SetProxyProtocol(ProxyProtocolRequired)
+	s.SetDefaultHandler(func(c Context) error {
+		remoteAddr <- c.RemoteAddr().String()
+		return nil
+	})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() { done <- s.Serve(ln) }()
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("PROXY TCP4 203.0.113.7 10.0.0.1 40000 443\r\nx")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case addr := <-remoteAddr:
+		if addr != "203.0.113.7:40000" {
+			t.Errorf("remote addr %s", addr)
+		}
+	case <-time.After(time.Second):
+		t.Error("message not handled")
+	}
+	_ = s.Stop()
+	<-done
+}
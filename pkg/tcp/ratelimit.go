@@ -0,0 +1,222 @@
+package tcp
+
+import (
+	"github.com/pkg/errors"
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RateLimited 是消息超过限速时返回的错误。
+var RateLimited = errors.New("rate limit exceeded")
+
+// RateLimitPolicy 决定消息超过限速时如何处理。
+type RateLimitPolicy int
+
+const (
+	// RateLimitDrop 丢弃这条消息，不交给后续的处理函数。
+	RateLimitDrop RateLimitPolicy = iota
+	// RateLimitDelay 等到有令牌时再交给后续的处理函数。
+	// 等待期间该连接的 Processor 被阻塞，进而暂停从连接收取数据，对客户端形成背压。
+	RateLimitDelay
+	// RateLimitDisconnect 关闭连接，并返回 RateLimited 。
+	RateLimitDisconnect
+)
+
+func (p RateLimitPolicy) String() string {
+	switch p {
+	case RateLimitDrop:
+		return "drop"
+	case RateLimitDelay:
+		return "delay"
+	case RateLimitDisconnect:
+		return "disconnect"
+	default:
+		return "unknown"
+	}
+}
+
+// ByRemoteIP 按远端 IP 分组，同一个 IP 的多个连接共享限额。
+func ByRemoteIP(c Context) string {
+	addr := c.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// ByRouteName 按路由名分组。用于 Server.Add 的路由中间件，限制某个路由的总流量。
+func ByRouteName(c Context) string {
+	return c.RouteName()
+}
+
+// RateLimitConfig 是 RateLimiter 的配置。
+type RateLimitConfig struct {
+	Rate        float64          // 每秒补充的令牌数。小于等于0表示不限速
+	Burst       int              // 令牌桶容量，即允许的突发消息数。小于1时按1处理
+	Key         KeyFunc          // 令牌桶的分组键，例如 ByConnectionID 、 ByRemoteIP 、 ByRouteName 。nil 表示所有消息共享一个令牌桶
+	Policy      RateLimitPolicy  // 超过限速时的处理方式
+	IdleTimeout time.Duration    // 令牌桶多久没有用到就被清除。0表示 DefaultRateLimitIdleTimeout
+	Metrics     MetricsCollector // 超过限速时上报 MessageRateLimited 。nil 表示不上报
+}
+
+// DefaultRateLimitIdleTimeout 是 RateLimitConfig.IdleTimeout 的默认值。
+const DefaultRateLimitIdleTimeout = time.Minute * 10
+
+// RateLimitStats 是 RateLimiter 的累计计数。
+type RateLimitStats struct {
+	Allowed      uint64 // 直接放行的消息数
+	Dropped      uint64 // RateLimitDrop 丢弃的消息数
+	Delayed      uint64 // RateLimitDelay 延迟放行的消息数
+	Disconnected uint64 // RateLimitDisconnect 关闭的连接数
+}
+
+// RateLimiter 是基于令牌桶的限速器。用 Middleware 得到中间件。
+type RateLimiter struct {
+	config    RateLimitConfig
+	mutex     sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+	now       func() time.Time
+
+	allowed      uint64
+	dropped      uint64
+	delayed      uint64
+	disconnected uint64
+}
+
+// NewRateLimiter 创建一个限速器。
+func NewRateLimiter(config RateLimitConfig) *RateLimiter {
+	if config.Burst < 1 {
+		config.Burst = 1
+	}
+	if config.IdleTimeout <= 0 {
+		config.IdleTimeout = DefaultRateLimitIdleTimeout
+	}
+	return &RateLimiter{
+		config:    config,
+		buckets:   make(map[string]*tokenBucket),
+		lastSweep: time.Now(),
+		now:       time.Now,
+	}
+}
+
+// RateLimit 是 NewRateLimiter(config).Middleware() 的简写。
+func RateLimit(config RateLimitConfig) MiddlewareFunc {
+	return NewRateLimiter(config).Middleware()
+}
+
+// Middleware 返回限速中间件。可以用 Server.Use 对所有消息限速，也可以用 Server.Add 只对某个路由限速。
+func (l *RateLimiter) Middleware() MiddlewareFunc {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c Context) error {
+			key := ""
+			if l.config.Key != nil {
+				key = l.config.Key(c)
+			}
+			wait := l.reserve(key)
+			if wait <= 0 {
+				atomic.AddUint64(&l.allowed, 1)
+				return next(c)
+			}
+			if l.config.Metrics != nil {
+				l.config.Metrics.MessageRateLimited(l.config.Policy.String())
+			}
+			switch l.config.Policy {
+			case RateLimitDelay:
+				atomic.AddUint64(&l.delayed, 1)
+				c.Logger().Debug("tcp: rate limited", slog.String("key", key), slog.String("policy", "delay"), slog.Duration("wait", wait))
+				timer := time.NewTimer(wait)
+				defer timer.Stop()
+				select {
+				case <-timer.C:
+					return next(c)
+				case <-c.Context().Done():
+					return c.Context().Err()
+				}
+			case RateLimitDisconnect:
+				l.cancel(key)
+				atomic.AddUint64(&l.disconnected, 1)
+				c.Logger().Warn("tcp: rate limited", slog.String("key", key), slog.String("policy", "disconnect"))
+				c.Close()
+				return RateLimited
+			default:
+				l.cancel(key)
+				atomic.AddUint64(&l.dropped, 1)
+				c.Logger().Debug("tcp: rate limited", slog.String("key", key), slog.String("policy", "drop"))
+				return nil
+			}
+		}
+	}
+}
+
+// Stats 返回累计计数。
+func (l *RateLimiter) Stats() RateLimitStats {
+	return RateLimitStats{
+		Allowed:      atomic.LoadUint64(&l.allowed),
+		Dropped:      atomic.LoadUint64(&l.dropped),
+		Delayed:      atomic.LoadUint64(&l.delayed),
+		Disconnected: atomic.LoadUint64(&l.disconnected),
+	}
+}
+
+// reserve 从 key 的令牌桶取一个令牌，返回需要等待的时长。令牌不够时也会预支，不需要等待则返回0。
+func (l *RateLimiter) reserve(key string) time.Duration {
+	if l.config.Rate <= 0 {
+		return 0
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	now := l.now()
+	l.sweep(now)
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: float64(l.config.Burst), updated: now}
+		l.buckets[key] = b
+	}
+	b.refill(now, l.config.Rate, float64(l.config.Burst))
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / l.config.Rate * float64(time.Second))
+}
+
+// cancel 归还 reserve 预支的令牌。用于不会放行的消息。
+func (l *RateLimiter) cancel(key string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if b, ok := l.buckets[key]; ok {
+		b.tokens++
+	}
+}
+
+// sweep 每隔 IdleTimeout 清除一次长时间没有用到的令牌桶。
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.config.IdleTimeout {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if now.Sub(b.updated) >= l.config.IdleTimeout {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+func (b *tokenBucket) refill(now time.Time, rate float64, burst float64) {
+	if elapsed := now.Sub(b.updated); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * rate
+		if b.tokens > burst {
+			b.tokens = burst
+		}
+	}
+	b.updated = now
+}
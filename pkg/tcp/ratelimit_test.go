@@ -0,0 +1,79 @@
+package tcp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+)
+
+func newRateLimitTestContext(remoteAddr string, closed *bool) *handleContext {
+	addr, _ := net.ResolveTCPAddr("tcp", remoteAddr)
+	return &handleContext{
+		remoteAddr: addr,
+		ctx:        context.Background(),
+		closeFunc:  func() { *closed = true },
+		logger:     slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+}
+
+func TestRateLimiter_Middleware(t *testing.T) {
+	for _, policy := range []RateLimitPolicy{RateLimitDrop, RateLimitDelay, RateLimitDisconnect} {
+		limiter := NewRateLimiter(RateLimitConfig{
+			Rate:   100,
+			Burst:  2,
+			Key:    ByRemoteIP,
+			Policy: policy,
+		})
+		handled := 0
+		h := limiter.Middleware()(func(c Context) error {
+			handled++
+			return nil
+		})
+		closed := false
+		var lastErr error
+		start := time.Now()
+		for i := 0; i < 3; i++ {
+			// 同一个 IP 的不同连接共享令牌桶
+			lastErr = h(newRateLimitTestContext([]string{"10.0.0.1:1000", "10.0.0.1:2000"}[i%2], &closed))
+		}
+		// 另一个 IP 有自己的令牌桶
+		_ = h(newRateLimitTestContext("10.0.0.2:1000", new(bool)))
+		stats := limiter.Stats()
+		switch policy {
+		case RateLimitDrop:
+			if handled != 3 || stats.Dropped != 1 || lastErr != nil || closed {
+				t.Errorf("drop: handled=%d stats=%+v err=%v closed=%v", handled, stats, lastErr, closed)
+			}
+		case RateLimitDelay:
+			if handled != 4 || stats.Delayed != 1 || time.Since(start) < 5*time.Millisecond {
+				t.Errorf("delay: handled=%d stats=%+v elapsed=%v", handled, stats, time.Since(start))
+			}
+		case RateLimitDisconnect:
+			if handled != 3 || stats.Disconnected != 1 || !errors.Is(lastErr, RateLimited) || !closed {
+				t.Errorf("disconnect: handled=%d stats=%+v err=%v closed=%v", handled, stats, lastErr, closed)
+			}
+		}
+	}
+}
+
+func TestRateLimiter_unlimited(t *testing.T) {
+	// Rate 不是正数时不限速，RateLimitDelay 也不会一直等下去
+	limiter := NewRateLimiter(RateLimitConfig{Rate: 0, Burst: 1, Policy: RateLimitDelay})
+	h := limiter.Middleware()(func(c Context) error { return nil })
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	for i := 0; i < 10; i++ {
+		c := newRateLimitTestContext("10.0.0.1:1000", new(bool))
+		c.ctx = ctx
+		if err := h(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if stats := limiter.Stats(); stats.Allowed != 10 || stats.Delayed != 0 {
+		t.Errorf("got %+v", stats)
+	}
+}
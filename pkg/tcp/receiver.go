@@ -3,7 +3,8 @@ package tcp
 import (
 	"bytes"
 	"context"
-	"errors"
+	"github.com/pkg/errors"
+	"log/slog"
 )
 
 // Receiver 负责从 net.Conn 收取字节流，拆分成 Envelope 后写入 channel 。
@@ -12,15 +13,32 @@ import (
 // 不负责关闭 net.Conn 。
 type Receiver struct {
 	connection             *Connection
-	splitter               SplitterFunc
+	protocols              *protocolSelector
+	codecs                 *codecChain
 	receivedMessageChannel chan<- ReceivedMessage
+	logger                 *slog.Logger
+	metrics                MetricsCollector
+	recorder               Recorder
 }
 
+// NewReceiver 创建一个用 splitter 拆分消息的 Receiver ，不支持多协议和帧编解码。
+//
+// Deprecated: Receiver 和同一个连接的 Sender 、 Processor 共享状态，应该用 NewDaemon 创建。
 func NewReceiver(connection *Connection, splitter SplitterFunc, receivedMessageChannel chan<- ReceivedMessage) *Receiver {
+	protocols := newProtocolSelector(&DaemonConfig{Splitter: splitter})
+	return newReceiver(connection, protocols, newCodecChain(connection.connectionID, nil), receivedMessageChannel, slog.Default(), NopMetricsCollector{}, nil)
+}
+
+// newReceiver 由 Daemon 调用。protocols 和 codecs 和同一个连接的 Sender 、 Processor 共享。
+func newReceiver(connection *Connection, protocols *protocolSelector, codecs *codecChain, receivedMessageChannel chan<- ReceivedMessage, logger *slog.Logger, metrics MetricsCollector, recorder Recorder) *Receiver {
 	return &Receiver{
 		connection:             connection,
-		splitter:               splitter,
+		protocols:              protocols,
+		codecs:                 codecs,
 		receivedMessageChannel: receivedMessageChannel,
+		logger:                 logger,
+		metrics:                metrics,
+		recorder:               recorder,
 	}
 }
 
@@ -32,22 +50,64 @@ func (r *Receiver) KeepWorking(ctx context.Context) error {
 			return errors.New("context is done")
 		default:
 		}
-		if data, err := r.receiveOneData(); err != nil {
-			return err
-		} else {
-			buf.Write(data)
-			if message, messageByteLength, err := r.splitter(buf.Bytes()); err != nil {
-				if errors.Is(err, NoEnoughData) {
-					continue
-				} else {
+		protocol := r.protocols.selected()
+		if protocol == nil {
+			// 还没有确定协议时，先用收到的字节嗅探。
+			if p, err := r.protocols.sniff(buf.Bytes()); err != nil {
+				if !errors.Is(err, NoEnoughData) {
+					r.logger.Warn("tcp: sniff failed", errorAttr("error", err), "buffered", buf.Len())
 					return err
 				}
+				if data, err := r.receiveOneData(); err != nil {
+					return err
+				} else {
+					buf.Write(data)
+				}
+				continue
 			} else {
-				r.receivedMessageChannel <- message
-				if n, err := buf.Read(make([]byte, messageByteLength)); err != nil {
+				r.logger.Debug("tcp: protocol selected", "protocol", p.name)
+				protocol = p
+			}
+		}
+		// 先把 buf 里已有的完整消息都拆出来，数据不够时再收取。
+		if message, messageByteLength, err := protocol.splitter(buf.Bytes()); err != nil {
+			if !errors.Is(err, NoEnoughData) {
+				r.logger.Warn("tcp: split failed", errorAttr("error", err), "buffered", buf.Len())
+				return err
+			}
+			if data, err := r.receiveOneData(); err != nil {
+				return err
+			} else {
+				buf.Write(data)
+			}
+		} else if message == nil || messageByteLength <= 0 || messageByteLength > buf.Len() {
+			// 拆分器的错误会让后面的数据全部错位，所以直接断开连接。
+			err := errors.Wrapf(BadSplitter, "consumed %d of %d bytes, packet is nil: %v", messageByteLength, buf.Len(), message == nil)
+			r.logger.Warn("tcp: split failed", errorAttr("error", err), "buffered", buf.Len())
+			return err
+		} else {
+			r.metrics.FrameReceived()
+			r.connection.stats.framesReceived.Add(1)
+			if r.recorder != nil {
+				r.recorder.Record(r.connection.connectionID, Inbound, buf.Bytes()[:messageByteLength])
+			}
+			if payload, err := r.codecs.decode(message.Bytes()); err != nil {
+				r.logger.Warn("tcp: decode failed", errorAttr("error", err))
+				return err
+			} else {
+				message = &Packet{data: payload}
+			}
+			select {
+			case r.receivedMessageChannel <- message:
+			case <-ctx.Done():
+				return errors.New("context is done")
+			}
+			buf.Next(messageByteLength)
+			if upgrade, ok := r.protocols.wait(ctx); !ok {
+				return errors.New("context is done")
+			} else if upgrade != nil {
+				if err := r.startTLS(ctx, buf, upgrade); err != nil {
 					return err
-				} else if n != messageByteLength {
-					return errors.New("something wrong here")
 				}
 			}
 		}
@@ -59,6 +119,8 @@ func (r *Receiver) receiveOneData() ([]byte, error) {
 	if n, err := r.connection.conn.Read(buf); err != nil {
 		return nil, err
 	} else {
+		r.metrics.BytesReceived(n)
+		r.connection.stats.bytesReceived.Add(int64(n))
 		return buf[:n], nil
 	}
 }
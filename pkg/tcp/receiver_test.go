@@ -0,0 +1,83 @@
+package tcp
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// receiverTestLineSplitter 按换行符拆包，包里不含换行符。
+func receiverTestLineSplitter(buf []byte) (*Packet, int, error) {
+	if i := bytes.IndexByte(buf, '\n'); i < 0 {
+		return nil, 0, NoEnoughData
+	} else {
+		return NewPacket(buf[:i]), i + 1, nil
+	}
+}
+
+func TestReceiver_splitBufferedFrames(t *testing.T) {
+	s := NewServer()
+	s.SetSplitter(receiverTestLineSplitter)
+	s.SetDefaultHandler(func(c Context) error {
+		c.Send(NewPacket(append(append([]byte("re:"), c.Received().(*Packet).Bytes()...), '\n')))
+		return nil
+	})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- s.Serve(ln) }()
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// 两条消息在一次写入里到达，第二条不能等到下一次收取才处理。
+	if _, err := conn.Write([]byte("a\nb\n")); err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	want := "re:a\nre:b\n"
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatalf("read %q: %v", got, err)
+	}
+	if string(got) != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	_ = s.Stop()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewReceiver_NewSender(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	connection := &Connection{connectionID: "conn1", conn: server}
+	received := make(chan ReceivedMessage)
+	sending := make(chan SendingMessage)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = NewReceiver(connection, receiverTestLineSplitter, received).KeepWorking(ctx) }()
+	go func() { _ = NewSender(connection, sending).KeepWorking(ctx) }()
+	_ = client.SetDeadline(time.Now().Add(time.Second))
+
+	go func() { _, _ = client.Write([]byte("a\nb\n")) }()
+	for _, want := range []string{"a", "b"} {
+		if m := (<-received).(*Packet); string(m.Bytes()) != want {
+			t.Errorf("received %q, want %q", m.Bytes(), want)
+		}
+	}
+	sending <- NewPacket([]byte("reply"))
+	got := make([]byte, len("reply"))
+	if _, err := io.ReadFull(client, got); err != nil || string(got) != "reply" {
+		t.Errorf("got %q, %v", got, err)
+	}
+}
@@ -0,0 +1,19 @@
+package tcp
+
+// Direction 是帧的方向。
+type Direction string
+
+const (
+	Inbound  Direction = "in"  // 从连接收到的帧
+	Outbound Direction = "out" // 发送到连接的帧
+)
+
+// Recorder 记录连接上收发的帧，用于抓包和回放（见 capture 子包）。用 Server.SetRecorder 设置。
+//
+// 记录的是网络上的原始字节：收到的帧是 SplitterFunc 拆分出的完整的帧，在 FrameCodec 解码之前；
+// 发送的帧是 FrameCodec 编码、FramerFunc 加上帧头之后的完整的帧。使用 TLS 时记录的是解密后的字节。
+// Record 在 Receiver 和 Sender 的协程里同步调用，会被多个连接并发调用，应该尽快返回。
+// frame 在 Record 返回后可能被修改，需要保存时要复制。
+type Recorder interface {
+	Record(connectionID ConnectionID, direction Direction, frame []byte)
+}
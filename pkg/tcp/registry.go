@@ -0,0 +1,182 @@
+package tcp
+
+import (
+	"github.com/pkg/errors"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ServerState 是 Server 的运行状态，见 Server.Status 。
+type ServerState string
+
+const (
+	StateIdle     ServerState = "idle"     // 还没有调用 Start 或 Serve
+	StateServing  ServerState = "serving"  // 正在接受新连接
+	StateDraining ServerState = "draining" // 调用了 Drain ，不再接受新连接，等待已有连接结束
+	StateStopped  ServerState = "stopped"  // Start 或 Serve 已经返回
+)
+
+// ServerStatus 是 Server 的状态。
+type ServerStatus struct {
+	State       ServerState
+	Address     string // 监听的地址
+	Connections int    // 正在服务的连接数
+}
+
+// ConnectionStats 是一个正在服务的连接的状态。
+type ConnectionStats struct {
+	ConnectionID   ConnectionID
+	RemoteAddr     net.Addr
+	LocalAddr      net.Addr
+	ConnectedAt    time.Time
+	BytesReceived  int64
+	BytesSent      int64
+	FramesReceived int64
+	FramesSent     int64
+	SendQueueDepth int                    // 放入了待发送消息队列、还没有发送完的消息数
+	Principal      interface{}            // Context.Authenticate 记录的身份
+	Values         map[string]interface{} // Context.SetValue 保存的 visible 的值
+}
+
+// connectionRegistry 记录正在服务的连接，即通过了准入、Daemon 还没有退出的连接。
+type connectionRegistry struct {
+	mutex       sync.RWMutex
+	connections map[ConnectionID]*Connection
+}
+
+func (r *connectionRegistry) add(c *Connection) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.connections == nil {
+		r.connections = make(map[ConnectionID]*Connection)
+	}
+	r.connections[c.connectionID] = c
+}
+
+func (r *connectionRegistry) remove(id ConnectionID) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.connections, id)
+}
+
+func (r *connectionRegistry) get(id ConnectionID) *Connection {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.connections[id]
+}
+
+func (r *connectionRegistry) len() int {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return len(r.connections)
+}
+
+func (r *connectionRegistry) all() []*Connection {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	connections := make([]*Connection, 0, len(r.connections))
+	for _, c := range r.connections {
+		connections = append(connections, c)
+	}
+	return connections
+}
+
+// Connections 返回所有正在服务的连接的状态，按建立的时间排列。
+func (s *Server) Connections() []ConnectionStats {
+	connections := s.registry.all()
+	stats := make([]ConnectionStats, 0, len(connections))
+	for _, c := range connections {
+		stats = append(stats, ConnectionStats{
+			ConnectionID:   c.connectionID,
+			RemoteAddr:     c.info.RemoteAddr,
+			LocalAddr:      c.info.LocalAddr,
+			ConnectedAt:    c.connectedAt,
+			BytesReceived:  c.stats.bytesReceived.Load(),
+			BytesSent:      c.stats.bytesSent.Load(),
+			FramesReceived: c.stats.framesReceived.Load(),
+			FramesSent:     c.stats.framesSent.Load(),
+			SendQueueDepth: int(c.stats.sendQueueDepth.Load()),
+			Principal:      c.session.getPrincipal(),
+			Values:         c.session.visibleValues(),
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].ConnectedAt.Before(stats[j].ConnectedAt) })
+	return stats
+}
+
+// Disconnect 以 ConnectionDisconnected 为原因中断一个连接。没有这个连接时返回 false 。
+// 和 Context.Close 一样，已经放入待发送消息队列的消息仍会尝试发送。
+func (s *Server) Disconnect(id ConnectionID) bool {
+	if c := s.registry.get(id); c != nil {
+		c.cancel(ConnectionDisconnected)
+		return true
+	}
+	return false
+}
+
+// Drain 让 Server 不再接受新连接，等待已有的连接自己结束之后 Start 或 Serve 才返回，用于平滑下线。
+// 超过 timeout 仍未结束的连接以 ServerStopped 为原因中断，0表示一直等待。Drain 之后仍然可以调用 Stop 立刻中断所有连接。
+func (s *Server) Drain(timeout time.Duration) error {
+	s.statusMutex.Lock()
+	if s.state != StateServing {
+		state := s.state
+		s.statusMutex.Unlock()
+		return errors.Errorf("tcp: cannot drain a server in state %s", state)
+	}
+	s.state = StateDraining
+	s.drainTimeout = timeout
+	s.drainAborted = make(chan struct{})
+	s.statusMutex.Unlock()
+	s.logger().Info("tcp: draining", "connections", s.registry.len(), "timeout", timeout)
+	return s.listener.Stop()
+}
+
+// Status 返回 Server 的状态。
+func (s *Server) Status() ServerStatus {
+	s.statusMutex.Lock()
+	defer s.statusMutex.Unlock()
+	return ServerStatus{State: s.state, Address: s.address, Connections: s.registry.len()}
+}
+
+// setServing 在 Serve 开始接受连接时调用。
+func (s *Server) setServing(address string) {
+	s.statusMutex.Lock()
+	defer s.statusMutex.Unlock()
+	s.state = StateServing
+	s.address = address
+}
+
+// waitDrained 在 Serve 的监听结束后调用。调用过 Drain 时等待 wg 里的连接结束，直到超时或者调用了 Stop 。
+func (s *Server) waitDrained(wg *sync.WaitGroup) {
+	s.statusMutex.Lock()
+	draining, timeout, aborted := s.state == StateDraining, s.drainTimeout, s.drainAborted
+	s.statusMutex.Unlock()
+	if !draining {
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case <-done:
+	case <-expired:
+		s.logger().Warn("tcp: drain timed out", "connections", s.registry.len())
+	case <-aborted:
+	}
+}
+
+func (s *Server) setStopped() {
+	s.statusMutex.Lock()
+	defer s.statusMutex.Unlock()
+	s.state = StateStopped
+}
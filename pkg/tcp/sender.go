@@ -3,6 +3,7 @@ package tcp
 import (
 	"context"
 	"github.com/pkg/errors"
+	"log/slog"
 	"time"
 )
 
@@ -12,13 +13,33 @@ import (
 // 不负责关闭 channel
 type Sender struct {
 	connection            *Connection
+	codecs                *codecChain
 	sendingMessageChannel <-chan SendingMessage
+	framer                FramerFunc
+	logger                *slog.Logger
+	slowWriteThreshold    time.Duration
+	metrics               MetricsCollector
+	recorder              Recorder
 }
 
+// NewSender 创建一个直接发送 SendingMessage.Bytes 的 Sender ，不支持帧编解码。
+//
+// Deprecated: Sender 和同一个连接的 Receiver 、 Processor 共享状态，应该用 NewDaemon 创建。
 func NewSender(connection *Connection, sendingMessageChannel <-chan SendingMessage) *Sender {
+	return newSender(connection, newCodecChain(connection.connectionID, nil), sendingMessageChannel, nil, slog.Default(), 0, NopMetricsCollector{}, nil)
+}
+
+// newSender 由 Daemon 调用。codecs 和同一个连接的 Receiver 、 Processor 共享。
+func newSender(connection *Connection, codecs *codecChain, sendingMessageChannel <-chan SendingMessage, framer FramerFunc, logger *slog.Logger, slowWriteThreshold time.Duration, metrics MetricsCollector, recorder Recorder) *Sender {
 	return &Sender{
 		connection:            connection,
+		codecs:                codecs,
 		sendingMessageChannel: sendingMessageChannel,
+		framer:                framer,
+		logger:                logger,
+		slowWriteThreshold:    slowWriteThreshold,
+		metrics:               metrics,
+		recorder:              recorder,
 	}
 }
 
@@ -33,9 +54,26 @@ func (s *Sender) KeepWorking(ctx context.Context) error {
 			if !ok {
 				return errors.New("channel is closed")
 			}
-			if err := s.send(m); err != nil {
+			if change, ok := m.(*codecChange); ok {
+				s.codecs.outbound[change.index] = change.enabled
+				continue
+			}
+			if pause, ok := m.(*senderPause); ok {
+				close(pause.flushed)
+				select {
+				case <-pause.resume:
+					continue
+				case <-ctx.Done():
+					return errors.New("context is done")
+				}
+			}
+			err := s.send(m)
+			s.connection.stats.dequeue()
+			if err != nil {
 				return err
 			}
+			// 出队之后再报告，FrameSent 里看到的 Server.Connections 已经更新
+			s.metrics.FrameSent()
 		}
 	}
 }
@@ -45,16 +83,46 @@ func (s *Sender) KeepWorking(ctx context.Context) error {
 // 如果发送出错，可能只发送了半条消息。所以如果返回值不是nil，应该立刻关闭连接，避免后续数据出错。
 func (s *Sender) send(m Serializable) error {
 	const maxWait = time.Second * 1 // 最多 maxWait 要发完
-	buf := m.Bytes()
-	if err := s.connection.conn.SetWriteDeadline(time.Now().Add(maxWait)); err != nil {
+	buf, err := s.frame(m.Bytes())
+	if err != nil {
+		s.logger.Warn("tcp: encode failed", errorAttr("error", err))
+		return err
+	}
+	if s.recorder != nil {
+		s.recorder.Record(s.connection.connectionID, Outbound, buf)
+	}
+	start := time.Now()
+	if err := s.connection.conn.SetWriteDeadline(start.Add(maxWait)); err != nil {
 		return err
 	}
+	defer func(size int) {
+		if elapsed := time.Since(start); s.slowWriteThreshold > 0 && elapsed > s.slowWriteThreshold {
+			s.logger.Warn("tcp: slow write", "duration", elapsed, "bytes", size)
+		}
+	}(len(buf))
 	for len(buf) > 0 {
 		if wc, err := s.connection.conn.Write(buf); err != nil {
+			s.metrics.BytesSent(wc)
+			s.connection.stats.bytesSent.Add(int64(wc))
 			return err
 		} else {
+			s.metrics.BytesSent(wc)
+			s.connection.stats.bytesSent.Add(int64(wc))
 			buf = buf[wc:]
 		}
 	}
+	s.connection.stats.framesSent.Add(1)
 	return nil
 }
+
+// frame 用启用的 FrameCodec 编码 payload ，再用 FramerFunc 加上帧头。
+func (s *Sender) frame(payload []byte) ([]byte, error) {
+	payload, err := s.codecs.encode(payload)
+	if err != nil {
+		return nil, err
+	}
+	if s.framer != nil {
+		return s.framer(payload), nil
+	}
+	return payload, nil
+}
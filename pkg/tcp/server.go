@@ -2,9 +2,13 @@ package tcp
 
 import (
 	"context"
+	"crypto/tls"
 	"github.com/pkg/errors"
 	"github.com/seedjyh/go-tcp/pkg/tcp/uuid"
+	"log/slog"
+	"net"
 	"sync"
+	"time"
 )
 
 type (
@@ -13,15 +17,20 @@ type (
 	// 1. 根据 SplitterFunc 拆分成 Packet。
 	// 2. 通过一系列 MiddlewareFunc。用 Use 注册自定义 MiddlewareFunc，先注册的先执行。
 	// 3. 通过一系列 RouterPair。每个 RouterPair 由一个 IdentifierFunc 和一个 HandlerFunc 组成。用 Add 注册自定义 RouterPair ，先注册的先检查。一个匹配后，不再执行后续的 RouterPair。
+	//    用 Group 注册的路由组也是一个 RouterPair，匹配后先通过路由组的 MiddlewareFunc，再检查组内的 RouterPair。
 	// 4. 如果所有 RouterPair 的 IdentifierFunc 都不匹配，则会调用默认的 HandlerFunc。用 SetDefaultHandler 覆盖默认值。
-	// 此外，每个连接创建的时候，会回调一个 OnConnectedFunc，用于配置往该连接发送消息的消息 channel。用 SetOnConnected 覆盖默认值。
+	// 5. 如果上述处理返回了错误，则会调用 ErrorHandlerFunc。用 SetErrorHandler 覆盖默认值。
+	//    上述处理中的 panic 会被转换成 *PanicError 交给 ErrorHandlerFunc，之后按 PanicPolicy 决定是否关闭该连接。用 SetPanicPolicy 覆盖默认值。
+	// 用 AddProtocol 注册的其他协议有自己的 SplitterFunc 、 MiddlewareFunc 、 RouterPair 和默认 HandlerFunc ，连接最开始的字节决定使用哪个协议。
+	// 此外，每个连接创建的时候，会先调用 AdmissionFunc 决定是否接受该连接。用 SetAdmission 注册。
+	// 接受之后，会回调一个 OnConnectedFunc，用于配置往该连接发送消息的消息 channel。用 SetOnConnected 覆盖默认值。
 	//
 	// 一般的使用顺序如下：
 	// 1. s := NewServer()
 	// 2. （可选）用 SetSplitter 覆盖默认的分包器。
 	// 3. （可选）用 Use 注册中间件，用于解析、重写或处理消息。
-	// 4. （可选）用 Add 注册路由规则，用于预先配置特定消息的处理函数。
-	// 5. （可选）用 SetDefaultHandler 注册默认消息处理函数。
+	// 4. （可选）用 Add 注册路由规则，用于预先配置特定消息的处理函数。用 Group 注册带有专属中间件的路由组。
+	// 5. （可选）用 SetDefaultHandler 注册默认消息处理函数。用 SetErrorHandler 注册错误处理函数。
 	// 6. （可选）用 SetOnConnected 注册异步发送消息的队列。队列里的消息会均匀分散到已有的连接。
 	// 7. Start(address) 将会阻塞。
 	// 8. 在要退出时，调用 Stop() 通知上述阻塞的 Start 函数退出。
@@ -31,9 +40,34 @@ type (
 		middleware            []MiddlewareFunc   // 处理函数包裹器（中间件）
 		onConnected           OnConnectedFunc    // 新连接建立时的回调
 		onDisconnected        OnDisconnectedFunc // 已有连接中断时的回调
-		routers               []RouterPair
-		defaultHandler        HandlerFunc // 默认处理函数（没有被任何router访问的）
-		connectionIDGenerator Generator   // 连接ID的生成器
+		routers               []*RouterPair
+		defaultHandler        HandlerFunc      // 默认处理函数（没有被任何router访问的）
+		errorHandler          ErrorHandlerFunc // 处理函数返回错误时的回调
+		panicPolicy           PanicPolicy      // 处理函数 panic 之后如何处理该连接
+		connectionIDGenerator Generator        // 连接ID的生成器
+		logHandler            slog.Handler     // 日志输出。nil 表示使用 slog.Default()
+		slowWriteThreshold    time.Duration    // 一次发送超过这个时长会记录日志
+		metrics               MetricsCollector // 运行指标
+		tracer                Tracer           // 为每条消息创建 Span
+		handlerTimeout        time.Duration    // 每条消息的处理时限
+		processingMode        ProcessingMode   // 一个连接收到的消息如何被处理
+		concurrency           int              // 非 Sequential 模式下，一个连接最多同时处理的消息数
+		admission             AdmissionFunc    // 新连接建立 Daemon 之前的检查。nil 表示全部接受
+		proxyProtocol         ProxyProtocolMode
+		handshake             HandlerFunc   // 握手处理函数。nil 表示不需要握手
+		handshakeTimeout      time.Duration // 握手时限
+		protocols             []*Protocol   // 同一端口上的其他协议
+		framer                FramerFunc    // 给要发送的消息加上帧头
+		codecs                []Codec       // 对帧的内容做的变换
+		startTLS              bool          // 是否允许 Context.StartTLS
+		onClosed              OnClosedFunc  // 连接关闭之后的回调
+		recorder              Recorder      // 记录收发的帧
+		registry              connectionRegistry
+		statusMutex           sync.Mutex
+		state                 ServerState
+		address               string
+		drainTimeout          time.Duration
+		drainAborted          chan struct{} // Drain 之后调用 Stop 时关闭
 	}
 
 	IdentifierFunc func(m ReceivedMessage) bool
@@ -42,10 +76,16 @@ type (
 	HandlerFunc func(c Context) error
 
 	RouterPair struct {
+		name       string // 用于日志、指标等，见 SetName
 		identifier IdentifierFunc
 		handler    HandlerFunc
+		middleware []MiddlewareFunc // 只对这条路由生效的中间件
+		group      *Group           // 不是 nil 则表示这是一个路由组，忽略 handler
 	}
 
+	// ErrorHandlerFunc 是处理函数返回错误时的回调函数。可以通过 c 发送错误响应或关闭连接。
+	ErrorHandlerFunc func(c Context, err error)
+
 	// MiddlewareFunc 是 Packet 处理函数中间件的标准格式
 	MiddlewareFunc func(next HandlerFunc) HandlerFunc
 
@@ -54,6 +94,9 @@ type (
 
 	// OnDisconnectedFunc 是连接中断时的回调函数。在该函数返回后，各种资源将会被清除。
 	OnDisconnectedFunc func(connectionID ConnectionID)
+
+	// OnClosedFunc 是连接关闭之后的回调函数。reason 是连接结束的原因，被拒绝的连接也会回调，此时 reason 是拒绝的原因。
+	OnClosedFunc func(info *ConnectionInfo, reason error)
 )
 
 func NewServer() *Server {
@@ -64,16 +107,29 @@ func NewServer() *Server {
 		onConnected:           nil,
 		routers:               nil,
 		defaultHandler:        nil,
+		errorHandler:          nil,
 		connectionIDGenerator: nil,
+		logHandler:            nil,
+		slowWriteThreshold:    DefaultSlowWriteThreshold,
+		metrics:               NopMetricsCollector{},
+		tracer:                nopTracer{},
+		state:                 StateIdle,
 	}
 	s.splitter = DefaultSplitter
 	s.onConnected = DefaultOnConnected
 	s.onDisconnected = DefaultOnDisconnected
 	s.defaultHandler = DefaultHandler
+	s.errorHandler = DefaultErrorHandler
 	s.connectionIDGenerator = uuid.NewUUID32Generator()
 	return s
 }
 
+// DefaultSlowWriteThreshold 是默认的慢发送日志阈值。
+const DefaultSlowWriteThreshold = time.Millisecond * 100
+
+// DefaultRouteName 是由默认处理函数处理的消息的路由名。
+const DefaultRouteName = "default"
+
 // DefaultOnConnected 没有出站消息。
 func DefaultOnConnected(connectionID ConnectionID) (outSiteMessageBus <-chan SendingMessage) {
 	return nil
@@ -85,7 +141,25 @@ func DefaultOnDisconnected(connectionID ConnectionID) {
 
 // DefaultHandler 默认处理函数
 func DefaultHandler(c Context) error {
-	return errors.New("unknown message")
+	return UnknownMessage
+}
+
+// DefaultErrorHandler 记录错误日志。
+// 如果 err 包含 ReplyError ，会发送其中的 Reply ；如果 err 包含 CloseConnection ，会关闭连接。
+func DefaultErrorHandler(c Context, err error) {
+	var panicError *PanicError
+	if errors.As(err, &panicError) {
+		c.Logger().Error("tcp: handle message failed", errorAttr("error", err), slog.String("stack", string(panicError.Stack)))
+	} else {
+		c.Logger().Error("tcp: handle message failed", errorAttr("error", err))
+	}
+	var replyError *ReplyError
+	if errors.As(err, &replyError) && replyError.Reply != nil {
+		c.Send(replyError.Reply)
+	}
+	if errors.Is(err, CloseConnection) {
+		c.Close()
+	}
 }
 
 func (s *Server) SetSplitter(splitter SplitterFunc) {
@@ -100,10 +174,131 @@ func (s *Server) SetOnDisconnected(onDisconnected OnDisconnectedFunc) {
 	s.onDisconnected = onDisconnected
 }
 
+// SetOnClosed 设置连接关闭之后的回调。和 OnDisconnectedFunc 不同，它在 net.Conn 关闭之后调用，能得到连接结束的原因，
+// 并且被拒绝的连接也会回调。原因可以用 errors.Is 和 ConnectionClosedByHandler 等比较，也可以用 DisconnectReason 归类。
+func (s *Server) SetOnClosed(onClosed OnClosedFunc) {
+	s.onClosed = onClosed
+}
+
+// OnClosed 返回 SetOnClosed 设置的回调，用于在它之外再加一个回调。没有设置时返回 nil 。
+func (s *Server) OnClosed() OnClosedFunc {
+	return s.onClosed
+}
+
 func (s *Server) SetDefaultHandler(handler HandlerFunc) {
 	s.defaultHandler = handler
 }
 
+func (s *Server) SetErrorHandler(handler ErrorHandlerFunc) {
+	s.errorHandler = handler
+}
+
+// SetPanicPolicy 设置处理函数 panic 之后如何处理该连接。默认是 PanicContinue 。
+func (s *Server) SetPanicPolicy(policy PanicPolicy) {
+	s.panicPolicy = policy
+}
+
+// SetLogHandler 设置日志输出。默认使用 slog.Default() 。
+// 日志事件包括连接接入、连接建立、连接中断（带原因）、处理出错、分包出错和慢发送，都带有连接 ID 和远端地址。
+func (s *Server) SetLogHandler(handler slog.Handler) {
+	s.logHandler = handler
+}
+
+// SetSlowWriteThreshold 设置慢发送日志阈值。一次发送超过这个时长会记录日志。0表示不记录。
+func (s *Server) SetSlowWriteThreshold(threshold time.Duration) {
+	s.slowWriteThreshold = threshold
+}
+
+func (s *Server) logger() *slog.Logger {
+	if s.logHandler == nil {
+		return slog.Default()
+	}
+	return slog.New(s.logHandler)
+}
+
+// SetMetricsCollector 设置运行指标的收集器。默认是 NopMetricsCollector 。
+func (s *Server) SetMetricsCollector(collector MetricsCollector) {
+	s.metrics = collector
+}
+
+// SetTracer 设置 Tracer 。每条消息都会创建一个 Span ，处理函数可以通过 Context.Context 得到它。
+func (s *Server) SetTracer(tracer Tracer) {
+	s.tracer = tracer
+}
+
+// SetHandlerTimeout 设置每条消息的处理时限。到期后 Context.Context 会被取消，处理函数应该尽快返回。
+// 默认是0，表示不限制。
+func (s *Server) SetHandlerTimeout(timeout time.Duration) {
+	s.handlerTimeout = timeout
+}
+
+// SetProcessingMode 设置一个连接收到的消息如何被处理。默认是 Sequential 。
+// concurrency 是 PipelinedOrdered 和 PipelinedUnordered 模式下一个连接最多同时处理的消息数，Sequential 模式下忽略。
+func (s *Server) SetProcessingMode(mode ProcessingMode, concurrency int) {
+	s.processingMode = mode
+	s.concurrency = concurrency
+}
+
+// SetAdmission 设置新连接的检查函数。它在 Accept 之后、OnConnectedFunc 和 Daemon 之前被调用，返回错误则关闭连接。
+// 可以使用 IPFilter.Admit 。
+func (s *Server) SetAdmission(admission AdmissionFunc) {
+	s.admission = admission
+}
+
+// SetProxyProtocol 设置是否解析负载均衡在连接开头加上的 PROXY protocol 头。默认是 ProxyProtocolOff 。
+// 开启后 Context.RemoteAddr 、 ConnectionInfo 和日志里都是头里的真实客户端地址。
+// 头里的地址是无条件相信的，开启后监听的端口不应该让客户端直接访问，见 ProxyProtocolMode 。
+// 只对 Start 和没有包裹 TLS 的 Serve 生效；需要 TLS 时，应该在 tls.NewListener 里面包裹 NewProxyProtocolListener 。
+func (s *Server) SetProxyProtocol(mode ProxyProtocolMode) {
+	s.proxyProtocol = mode
+}
+
+// SetHandshake 设置握手处理函数。连接建立后收到的消息先逐条交给 handler ，不经过中间件和路由，
+// 直到 handler 调用了 Context.Authenticate ，之后的消息才交给正常的处理函数。
+// handler 返回错误时，错误先交给 ErrorHandlerFunc （例如发送 ReplyError 里的响应），然后以 HandshakeFailed 为原因关闭连接；
+// 连接建立后 timeout 内没有通过认证，以 HandshakeTimedOut 为原因关闭连接。timeout 为0表示不限制。
+func (s *Server) SetHandshake(handler HandlerFunc, timeout time.Duration) {
+	s.handshake = handler
+	s.handshakeTimeout = timeout
+}
+
+// AddProtocol 注册同一个端口上的另一种协议，返回的 Protocol 用来配置它的中间件和路由规则。
+// 连接最开始收到的字节会按注册顺序交给各个协议的 sniff ，第一个匹配的协议处理这个连接；都不匹配时使用 Server 本身的配置。
+// sniff 为 nil 的协议不参与嗅探，只能用 Context.SwitchProtocol 切换过去。name 不能是 DefaultProtocolName 。
+func (s *Server) AddProtocol(name string, sniff SniffFunc, splitter SplitterFunc) *Protocol {
+	p := &Protocol{
+		Group:    newGroup(nil),
+		name:     name,
+		sniff:    sniff,
+		splitter: splitter,
+	}
+	s.protocols = append(s.protocols, p)
+	return p
+}
+
+// SetFramer 设置给要发送的消息加上帧头的函数。默认是 nil ，表示 SendingMessage.Bytes 就是完整的帧。
+// 使用 FrameCodec 时必须设置，并且 SplitterFunc 拆分出的 Packet 只包含帧的内容，例如 LengthPrefixSplitter 和 LengthPrefixFramer 。
+func (s *Server) SetFramer(framer FramerFunc) {
+	s.framer = framer
+}
+
+// AddCodec 注册一个对帧的内容做的变换，例如压缩或加密。多个 FrameCodec 按注册顺序编码，按相反顺序解码。
+// enabled 为 false 时，新连接不使用它，直到处理函数调用 Context.SetCodecEnabled 。
+func (s *Server) AddCodec(name string, factory CodecFactory, enabled bool) {
+	s.codecs = append(s.codecs, Codec{Name: name, Factory: factory, Enabled: enabled})
+}
+
+// SetStartTLSEnabled 设置是否允许处理函数调用 Context.StartTLS 。默认是 false 。
+// 开启后 Sequential 模式下 Receiver 每交出一条消息都要等它处理完再拆分下一条，见 Context.StartTLS 。
+func (s *Server) SetStartTLSEnabled(enabled bool) {
+	s.startTLS = enabled
+}
+
+// SetRecorder 设置记录所有连接收发的帧的 Recorder 。默认是 nil ，表示不记录。
+func (s *Server) SetRecorder(recorder Recorder) {
+	s.recorder = recorder
+}
+
 func (s *Server) SetDefaultConnectionIDGenerator(generator Generator) {
 	s.connectionIDGenerator = generator
 }
@@ -114,59 +309,181 @@ func (s *Server) Use(middlewares ...MiddlewareFunc) {
 	}
 }
 
-func (s *Server) Add(identifier IdentifierFunc, handler HandlerFunc) {
-	s.routers = append(s.routers, RouterPair{
+// Add 注册一条路由规则。middlewares 只对这条路由生效，在 Use 注册的中间件之后执行。
+func (s *Server) Add(identifier IdentifierFunc, handler HandlerFunc, middlewares ...MiddlewareFunc) *RouterPair {
+	r := &RouterPair{
 		identifier: identifier,
 		handler:    handler,
+		middleware: middlewares,
+	}
+	s.routers = append(s.routers, r)
+	return r
+}
+
+// SetName 设置路由规则的名字。匹配后可以用 Context.RouteName 得到，也用作指标的标签。
+func (r *RouterPair) SetName(name string) *RouterPair {
+	r.name = name
+	return r
+}
+
+// Group 注册一个路由组。被 identifier 匹配的消息会依次经过 middlewares 和组内的路由规则。
+func (s *Server) Group(identifier IdentifierFunc, middlewares ...MiddlewareFunc) *Group {
+	g := newGroup(middlewares)
+	s.routers = append(s.routers, &RouterPair{
+		identifier: identifier,
+		group:      g,
 	})
+	return g
+}
+
+// buildHandler 把中间件、路由规则、默认处理函数组装成一个 HandlerFunc 。
+func (s *Server) buildHandler() HandlerFunc {
+	return applyMiddleware(buildRouter(s.routers, s.defaultHandler), s.middleware)
 }
 
 // Start 是一个阻塞式的服务。会一直工作到调用 Stop 为止。
 // 收到一个连接，就会启动一个协程去处理该连接。
 func (s *Server) Start(address string) error {
+	if ln, err := net.Listen("tcp", address); err != nil {
+		return err
+	} else {
+		return s.Serve(ln)
+	}
+}
+
+// Serve 和 Start 一样，但是在外部创建好的 ln 上接受连接。例如用 tls.NewListener 提供 TLS 服务。
+// Stop 会关闭 ln 。
+func (s *Server) Serve(ln net.Listener) error {
+	if len(s.codecs) > 0 && s.framer == nil {
+		return errors.New("tcp: codecs require a framer, see SetFramer")
+	}
 	var wg sync.WaitGroup
 	defer wg.Wait()
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-	s.listener = NewListener(s.connectionIDGenerator)
-	connChan, err := s.listener.Start(address)
-	if err != nil {
-		return err
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(ServerStopped)
+	logger := s.logger()
+	if s.proxyProtocol != ProxyProtocolOff {
+		ln = NewProxyProtocolListener(ln, s.proxyProtocol)
 	}
+	s.listener = NewListener(s.connectionIDGenerator)
+	connChan := s.listener.Serve(ln)
+	s.setServing(ln.Addr().String())
+	defer s.setStopped()
+	logger.Info("tcp: listening", "address", ln.Addr().String())
 
+	config := &DaemonConfig{
+		Splitter:           s.splitter,
+		Handler:            s.buildHandler(),
+		ErrorHandler:       s.errorHandler,
+		PanicPolicy:        s.panicPolicy,
+		OnConnected:        s.onConnected,
+		OnDisconnected:     s.onDisconnected,
+		Logger:             logger,
+		SlowWriteThreshold: s.slowWriteThreshold,
+		Metrics:            s.metrics,
+		Tracer:             s.tracer,
+		HandlerTimeout:     s.handlerTimeout,
+		ProcessingMode:     s.processingMode,
+		Concurrency:        s.concurrency,
+		Handshake:          s.handshake,
+		HandshakeTimeout:   s.handshakeTimeout,
+		Protocols:          s.protocols,
+		Framer:             s.framer,
+		Codecs:             s.codecs,
+		StartTLS:           s.startTLS,
+		Recorder:           s.recorder,
+	}
+	for _, p := range s.protocols {
+		p.build()
+	}
 	for conn := range connChan {
 		conn := conn
-		//fmt.Println("tcp.server.Start took a connection from listener:", conn)
-
-		h := func(c Context) error {
-			m := c.Received()
-			for _, r := range s.routers {
-				if r.identifier(m) {
-					return r.handler(c)
-				}
-			}
-			return s.defaultHandler(c)
-		}
-		for i := len(s.middleware) - 1; i >= 0; i-- {
-			h = s.middleware[i](h)
-		}
-
-		daemon := NewDaemon(conn, s.splitter, h, s.onConnected, s.onDisconnected)
-
 		wg.Add(1)
 		go func() {
-			wg.Done()
-			if err := daemon.KeepWorking(ctx); err != nil {
-				//fmt.Println("conn processor exit, error=", err)
-			} else {
-				//fmt.Println("conn processor exit ok")
+			defer wg.Done()
+			// 开启 PROXY protocol 时取远端地址需要先读取头，所以不能放在 Accept 的循环里。
+			info := &ConnectionInfo{
+				ConnectionID: conn.connectionID,
+				RemoteAddr:   conn.conn.RemoteAddr(),
+				LocalAddr:    conn.conn.LocalAddr(),
+			}
+			reason := s.serveConnection(ctx, conn, info, config, logger)
+			_ = conn.conn.Close()
+			if s.onClosed != nil {
+				s.onClosed(info, reason)
 			}
 		}()
 	}
+	logger.Info("tcp: listener stopped", errorAttr("error", s.listener.Err()))
+	s.waitDrained(&wg)
 	return nil
 }
 
+// serveConnection 检查并处理一个连接，返回连接结束的原因。不关闭连接。
+func (s *Server) serveConnection(ctx context.Context, conn *Connection, info *ConnectionInfo, config *DaemonConfig, logger *slog.Logger) error {
+	connLogger := conn.logger(logger)
+	connLogger.Debug("tcp: accepted")
+	if reason, err := s.admit(ctx, conn, info); err != nil {
+		connLogger.Info("tcp: rejected", slog.String("reason", reason), errorAttr("error", err))
+		s.metrics.ConnectionRejected(reason)
+		return err
+	}
+	s.metrics.ConnectionAccepted()
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	conn.info = info
+	conn.cancel = cancel
+	s.registry.add(conn)
+	defer s.registry.remove(conn.connectionID)
+	return NewDaemon(conn, config).KeepWorking(ctx)
+}
+
+// admit 在建立 Daemon 之前检查新连接：确认连接 ID 生成成功，读取 PROXY protocol 头，完成 TLS 握手，然后调用 AdmissionFunc 。
+// 检查中得到的信息写入 info 。拒绝时返回用作指标标签的原因和具体的错误。
+func (s *Server) admit(ctx context.Context, conn *Connection, info *ConnectionInfo) (string, error) {
+	if conn.idError != nil {
+		return RejectReasonConnectionID, conn.idError
+	}
+	netConn := conn.conn
+	if tlsConn, ok := netConn.(*tls.Conn); ok {
+		netConn = tlsConn.NetConn()
+	}
+	if pc, ok := netConn.(*proxyConn); ok {
+		if header, err := pc.Header(); err != nil {
+			return RejectReasonProxyProtocol, err
+		} else {
+			info.Proxy = header
+		}
+	}
+	if tlsConn, ok := conn.conn.(*tls.Conn); ok {
+		handshakeCtx, cancel := context.WithTimeout(ctx, TLSHandshakeTimeout)
+		defer cancel()
+		if err := tlsConn.HandshakeContext(handshakeCtx); err != nil {
+			return RejectReasonTLSHandshake, err
+		}
+		state := tlsConn.ConnectionState()
+		info.TLS = &state
+	}
+	if s.admission != nil {
+		if err := s.admission(info); err != nil {
+			return RejectReasonAdmission, err
+		}
+	}
+	return "", nil
+}
+
 // Stop 仅发送一个停止的信号， Start 需要等关闭所有资源后才返回。
 func (s *Server) Stop() error {
+	s.statusMutex.Lock()
+	defer s.statusMutex.Unlock()
+	if s.state == StateDraining {
+		// Drain 已经停止了监听，只需要结束等待。
+		select {
+		case <-s.drainAborted:
+		default:
+			close(s.drainAborted)
+		}
+		return nil
+	}
 	return s.listener.Stop()
 }
@@ -0,0 +1,38 @@
+package tcp
+
+import (
+	"github.com/pkg/errors"
+	"io"
+	"log/slog"
+	"testing"
+)
+
+func TestDefaultErrorHandler(t *testing.T) {
+	for _, tc := range []struct {
+		err        error
+		wantReply  bool
+		wantClosed bool
+	}{
+		{UnknownMessage, false, false},
+		{NewReplyError(NewPacket([]byte("bad")), BadMessageFormat), true, false},
+		{errors.Wrap(CloseConnection, "auth failed"), false, true},
+		{errors.Wrap(NewReplyError(NewPacket([]byte("bye")), CloseConnection), "kicked"), true, true},
+	} {
+		sending := make(chan SendingMessage, 1)
+		closed := false
+		c := &handleContext{
+			sendingMessageChannel: sending,
+			stats:                 &connectionStats{},
+			metrics:               NopMetricsCollector{},
+			closeFunc:             func() { closed = true },
+			logger:                slog.New(slog.NewTextHandler(io.Discard, nil)),
+		}
+		DefaultErrorHandler(c, tc.err)
+		if gotReply := len(sending) == 1; gotReply != tc.wantReply {
+			t.Errorf("error %v: reply sent %v, want %v", tc.err, gotReply, tc.wantReply)
+		}
+		if closed != tc.wantClosed {
+			t.Errorf("error %v: closed %v, want %v", tc.err, closed, tc.wantClosed)
+		}
+	}
+}
@@ -1,5 +1,10 @@
 package tcp
 
+import (
+	"encoding/binary"
+	"github.com/pkg/errors"
+)
+
 // SplitterFunc 检查buf，从其头部拆分出一个 Packet 。
 // 返回内容包括拆分出的 Packet 、读取的长度（通常和 Packet 字节数相同）。如果 error 不是 nil ，那么前两个返回值没有用。
 type SplitterFunc func(buf []byte) (*Packet, int, error)
@@ -11,3 +16,35 @@ func DefaultSplitter(buf []byte) (*Packet, int, error) {
 	}
 	return NewPacket(buf), len(buf), nil
 }
+
+// FramerFunc 给要发送的帧内容加上帧头，与只拆分出帧内容的 SplitterFunc 配对。见 Server.SetFramer 。
+type FramerFunc func(payload []byte) []byte
+
+// LengthPrefixSize 是 LengthPrefixSplitter 和 LengthPrefixFramer 使用的帧头长度。帧头是大端序的帧内容长度。
+const LengthPrefixSize = 4
+
+// LengthPrefixSplitter 拆分以4字节长度开头的帧，拆分出的 Packet 只包含帧的内容。
+// 帧内容超过 maxPayloadSize 时返回 BadMessageFormat 。
+func LengthPrefixSplitter(maxPayloadSize int) SplitterFunc {
+	return func(buf []byte) (*Packet, int, error) {
+		if len(buf) < LengthPrefixSize {
+			return nil, 0, NoEnoughData
+		}
+		size := int(binary.BigEndian.Uint32(buf))
+		if size > maxPayloadSize {
+			return nil, 0, errors.Wrapf(BadMessageFormat, "payload size %d exceeds %d", size, maxPayloadSize)
+		}
+		if len(buf) < LengthPrefixSize+size {
+			return nil, 0, NoEnoughData
+		}
+		return NewPacket(buf[LengthPrefixSize : LengthPrefixSize+size]), LengthPrefixSize + size, nil
+	}
+}
+
+// LengthPrefixFramer 在 payload 前面加上4字节长度。
+func LengthPrefixFramer(payload []byte) []byte {
+	frame := make([]byte, LengthPrefixSize+len(payload))
+	binary.BigEndian.PutUint32(frame, uint32(len(payload)))
+	copy(frame[LengthPrefixSize:], payload)
+	return frame
+}
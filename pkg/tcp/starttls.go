@@ -0,0 +1,98 @@
+package tcp
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"github.com/pkg/errors"
+	"net"
+	"time"
+)
+
+// tlsUpgrade 是 Context.StartTLS 要求的升级。
+// 由 Processor 在处理函数返回、Sender 发完之前的消息并暂停之后发起，由 Receiver 完成。
+type tlsUpgrade struct {
+	config *tls.Config
+	pause  *senderPause
+}
+
+// senderPause 是放入待发送消息队列的控制消息。Sender 收到它时，之前的消息都已经发送完了，
+// 于是关闭 flushed ，然后暂停到 resume 被关闭。
+type senderPause struct {
+	flushed chan struct{}
+	resume  chan struct{}
+}
+
+func newSenderPause() *senderPause {
+	return &senderPause{
+		flushed: make(chan struct{}),
+		resume:  make(chan struct{}),
+	}
+}
+
+// Bytes 让 senderPause 可以放入待发送消息队列。它不会被发送。
+func (p *senderPause) Bytes() []byte {
+	return nil
+}
+
+// pauseSender 让 Sender 发完队列里已有的消息后暂停。ctx 结束时返回 false 。
+func (p *Processor) pauseSender(ctx context.Context) (*senderPause, bool) {
+	pause := newSenderPause()
+	select {
+	case p.sendingMessageChannel <- pause:
+	case <-ctx.Done():
+		return nil, false
+	}
+	select {
+	case <-pause.flushed:
+		return pause, true
+	case <-ctx.Done():
+		return nil, false
+	}
+}
+
+// startTLS 在原来的连接上完成 TLS 握手，之后 Receiver 和 Sender 都使用加密后的连接。
+// buf 里还没有拆分的字节属于 TLS 握手，会先交给 tls.Server 。
+// 握手失败时 Sender 保持暂停，连接随后因为返回的错误而中断。
+func (r *Receiver) startTLS(ctx context.Context, buf *bytes.Buffer, upgrade *tlsUpgrade) error {
+	leftover := append([]byte(nil), buf.Bytes()...)
+	buf.Reset()
+	rawConn := r.connection.conn
+	// Sender 发送明文响应时设置的写入时限在客户端开始握手之前就可能过去，所以握手的读写都改用握手的时限。
+	if err := rawConn.SetDeadline(time.Now().Add(TLSHandshakeTimeout)); err != nil {
+		return err
+	}
+	conn := tls.Server(&prefixedConn{Conn: rawConn, prefix: leftover}, upgrade.config)
+	handshakeCtx, cancel := context.WithTimeout(ctx, TLSHandshakeTimeout)
+	defer cancel()
+	if err := conn.HandshakeContext(handshakeCtx); err != nil {
+		r.logger.Warn("tcp: start tls failed", errorAttr("error", err))
+		return err
+	}
+	if err := rawConn.SetDeadline(time.Time{}); err != nil {
+		return err
+	}
+	// 连接在握手期间中断时， Daemon 为了唤醒 Receiver 设置的读取时限可能被上面清除了。
+	if ctx.Err() != nil {
+		return errors.New("context is done")
+	}
+	r.connection.conn = conn
+	r.logger.Debug("tcp: tls started", "version", tls.VersionName(conn.ConnectionState().Version))
+	close(upgrade.pause.resume)
+	return nil
+}
+
+// prefixedConn 先读出 prefix ，再从 Conn 读取。
+type prefixedConn struct {
+	net.Conn
+	prefix []byte
+}
+
+func (c *prefixedConn) Read(b []byte) (int, error) {
+	if len(c.prefix) > 0 {
+		n := copy(b, c.prefix)
+		c.prefix = c.prefix[n:]
+		return n, nil
+	}
+	return c.Conn.Read(b)
+}
@@ -0,0 +1,131 @@
+package tcp
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"log/slog"
+	"math/big"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestTLSConfig(t *testing.T) *tls.Config {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+}
+
+// staleDeadlineListener 接受的连接第一次写入之后写入时限就过期了，
+// 和客户端收到明文响应之后过了 Sender 的写入时限才开始握手一样，但是不需要真的等待。
+type staleDeadlineListener struct {
+	net.Listener
+}
+
+func (l staleDeadlineListener) Accept() (net.Conn, error) {
+	if conn, err := l.Listener.Accept(); err != nil {
+		return nil, err
+	} else {
+		return &staleDeadlineConn{Conn: conn}, nil
+	}
+}
+
+type staleDeadlineConn struct {
+	net.Conn
+	once sync.Once
+}
+
+func (c *staleDeadlineConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.once.Do(func() { _ = c.Conn.SetWriteDeadline(time.Unix(1, 0)) })
+	return n, err
+}
+
+func TestContext_StartTLS(t *testing.T) {
+	// 客户端收到明文响应之后，可以过一会儿才开始握手，超过 Sender 的写入时限也不影响
+	for name, staleDeadline := range map[string]bool{"fresh": false, "staleDeadline": true} {
+		t.Run(name, func(t *testing.T) {
+			testStartTLS(t, staleDeadline)
+		})
+	}
+}
+
+func testStartTLS(t *testing.T, staleDeadline bool) {
+	config := newTestTLSConfig(t)
+	connectionIDs := make(chan ConnectionID, 2)
+	s := NewServer()
+	s.SetLogHandler(slog.NewTextHandler(io.Discard, nil))
+	s.SetSplitter(lineSplitter)
+	s.SetStartTLSEnabled(true)
+	s.SetDefaultHandler(func(c Context) error {
+		connectionIDs <- c.ConnectionID()
+		if string(c.Received().(*Packet).Bytes()) == "STARTTLS" {
+			c.Send(NewPacket([]byte("ready\n")))
+			return c.StartTLS(config)
+		}
+		state := "plain"
+		if c.TLS() != nil {
+			state = "tls"
+		}
+		c.Send(NewPacket([]byte(state + ":" + string(c.Received().(*Packet).Bytes()) + "\n")))
+		return nil
+	})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var serverListener net.Listener = ln
+	if staleDeadline {
+		serverListener = staleDeadlineListener{ln}
+	}
+	done := make(chan error)
+	go func() { done <- s.Serve(serverListener) }()
+	defer func() {
+		_ = s.Stop()
+		<-done
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(time.Second * 2))
+	if _, err := conn.Write([]byte("STARTTLS\n")); err != nil {
+		t.Fatal(err)
+	}
+	// 明文的响应必须在握手之前读出，所以只读取响应的长度，不能用 bufio 多读。
+	reply := make([]byte, len("ready\n"))
+	if _, err := io.ReadFull(conn, reply); err != nil || string(reply) != "ready\n" {
+		t.Fatalf("got %q, %v", reply, err)
+	}
+	tlsConn := tls.Client(conn, &tls.Config{InsecureSkipVerify: true})
+	if _, err := tlsConn.Write([]byte("hello\n")); err != nil {
+		t.Fatal(err)
+	}
+	if line, err := bufio.NewReader(tlsConn).ReadString('\n'); err != nil || line != "tls:hello\n" {
+		t.Errorf("got %q, %v", line, err)
+	}
+	if first, second := <-connectionIDs, <-connectionIDs; first != second {
+		t.Errorf("connection ID changed from %s to %s", first, second)
+	}
+}
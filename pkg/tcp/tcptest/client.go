@@ -0,0 +1,136 @@
+package tcptest
+
+import (
+	"errors"
+	"github.com/seedjyh/go-tcp/pkg/tcp"
+	"net"
+	"sync"
+	"time"
+)
+
+// Timeout 是 Client 的操作超时时返回的错误。
+var Timeout = errors.New("tcptest: timeout")
+
+// Client 是连接的客户端一侧。它在后台持续读取服务端发来的字节，所以服务端的发送不会因为测试代码没有读取而阻塞。
+type Client struct {
+	conn     net.Conn
+	reason   <-chan error
+	splitter tcp.SplitterFunc
+	Timeout  time.Duration // Write 、 NextFrame 和 WaitClosed 的默认时限
+
+	mutex    sync.Mutex
+	buf      []byte        // 收到但是还没有拆分的字节
+	readErr  error         // 后台读取结束的原因
+	received chan struct{} // 收到新的字节或者读取结束时通知
+}
+
+func newClient(conn net.Conn, reason <-chan error) *Client {
+	c := &Client{
+		conn:     conn,
+		reason:   reason,
+		splitter: tcp.DefaultSplitter,
+		Timeout:  DefaultTimeout,
+		received: make(chan struct{}, 1),
+	}
+	go c.keepReading()
+	return c
+}
+
+// SetSplitter 设置拆分服务端发来的字节流的函数。默认是 tcp.DefaultSplitter ，即收到的所有字节作为一帧。
+func (c *Client) SetSplitter(splitter tcp.SplitterFunc) {
+	c.splitter = splitter
+}
+
+func (c *Client) keepReading() {
+	buf := make([]byte, 4096)
+	for {
+		n, err := c.conn.Read(buf)
+		c.mutex.Lock()
+		c.buf = append(c.buf, buf[:n]...)
+		c.readErr = err
+		c.mutex.Unlock()
+		select {
+		case c.received <- struct{}{}:
+		default:
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Conn 返回底层的 net.Conn 。
+func (c *Client) Conn() net.Conn {
+	return c.conn
+}
+
+// Write 发送 b 。服务端收取之后才返回，超过 Timeout 返回错误。
+func (c *Client) Write(b []byte) error {
+	if err := c.conn.SetWriteDeadline(time.Now().Add(c.Timeout)); err != nil {
+		return err
+	}
+	_, err := c.conn.Write(b)
+	return err
+}
+
+// WriteChunks 把 b 拆成每份最多 size 字节，分别发送。服务端每次 Read 最多得到一份，用来测试分包器和 Receiver 的重组。
+func (c *Client) WriteChunks(b []byte, size int) error {
+	for len(b) > 0 {
+		n := size
+		if n > len(b) {
+			n = len(b)
+		}
+		if err := c.Write(b[:n]); err != nil {
+			return err
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+// NextFrame 等待服务端发来的下一帧，超过 Timeout 返回 Timeout 。连接已经关闭并且没有完整的帧时返回读取的错误，通常是 io.EOF 。
+func (c *Client) NextFrame() ([]byte, error) {
+	timer := time.NewTimer(c.Timeout)
+	defer timer.Stop()
+	for {
+		if frame, err := c.split(); frame != nil || err != nil {
+			return frame, err
+		}
+		select {
+		case <-c.received:
+		case <-timer.C:
+			return nil, Timeout
+		}
+	}
+}
+
+// split 从已经收到的字节拆出一帧。字节不够并且连接还没关闭时返回 nil, nil 。
+func (c *Client) split() ([]byte, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	packet, n, err := c.splitter(c.buf)
+	if err == nil {
+		frame := append([]byte(nil), packet.Bytes()...)
+		c.buf = c.buf[n:]
+		return frame, nil
+	}
+	if !errors.Is(err, tcp.NoEnoughData) {
+		return nil, err
+	}
+	return nil, c.readErr
+}
+
+// WaitClosed 等待服务端关闭连接，返回服务端记录的连接结束的原因（见 tcp.Server.SetOnClosed ）。超过 Timeout 返回 Timeout 。
+func (c *Client) WaitClosed() (reason error, err error) {
+	select {
+	case reason := <-c.reason:
+		return reason, nil
+	case <-time.After(c.Timeout):
+		return nil, Timeout
+	}
+}
+
+// Close 关闭客户端一侧。
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,268 @@
+package tcptest
+
+import (
+	"math/rand"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Faults 描述一次 Read 或 Write 要注入的故障。零值表示不注入故障。
+type Faults struct {
+	Latency time.Duration // 每次读写之前的延迟
+	Jitter  time.Duration // 延迟再随机增加 0 到 Jitter
+
+	ReadBandwidth  int // 每秒最多读取的字节数，0表示不限制
+	WriteBandwidth int // 每秒最多写入的字节数，0表示不限制
+
+	// MaxReadSize 是每次 Read 最多返回的字节数，0表示不限制。用来测试分包器和 Receiver 的重组。
+	MaxReadSize int
+	// MaxWriteSize 是每次 Write 最多写入的字节数，0表示不限制。
+	// 写入的字节不够时 Write 不返回错误，这违反了 io.Writer 的约定，用来测试调用方是否在循环里写完剩下的字节。
+	MaxWriteSize int
+	// WriteTimeout 让 Write 一直阻塞到写入时限（见 SetWriteDeadline ），然后返回 os.ErrDeadlineExceeded 。没有时限时立刻返回。
+	WriteTimeout bool
+	// Reset 立刻关闭连接，这次和之后的读写都返回 syscall.ECONNRESET 。对端看到的是连接被正常关闭。
+	Reset bool
+}
+
+// Op 是一次读写发生时连接的状态，Profile 根据它决定注入的故障。
+type Op struct {
+	Write   bool          // 是 Write 还是 Read
+	Elapsed time.Duration // 连接建立之后经过的时间
+	Read    int64         // 已经读取的字节数
+	Written int64         // 已经写入的字节数
+}
+
+// Profile 决定每次读写注入的故障。同一个 Profile 可以用于多个连接，每个连接的 Op 是独立的。
+type Profile func(op Op) Faults
+
+// Constant 返回总是注入 faults 的 Profile 。
+func Constant(faults Faults) Profile {
+	return func(op Op) Faults {
+		return faults
+	}
+}
+
+// Step 是 Script 的一个步骤。
+type Step struct {
+	After      time.Duration // 连接建立 After 之后才开始
+	AfterBytes int64         // 读写的字节数一共达到 AfterBytes 之后才开始
+	Faults     Faults
+}
+
+// Script 返回按步骤变化的 Profile 。每次读写使用最后一个已经开始的步骤，没有步骤开始时不注入故障。例如：
+//
+//	tcptest.Script(
+//		tcptest.Step{Faults: tcptest.Faults{Latency: 10 * time.Millisecond}},
+//		tcptest.Step{AfterBytes: 1024, Faults: tcptest.Faults{Reset: true}},
+//	)
+//
+// 表示前 1024 字节每次读写延迟 10ms ，之后重置连接。
+func Script(steps ...Step) Profile {
+	return func(op Op) Faults {
+		var faults Faults
+		for _, step := range steps {
+			if op.Elapsed >= step.After && op.Read+op.Written >= step.AfterBytes {
+				faults = step.Faults
+			}
+		}
+		return faults
+	}
+}
+
+// FaultConn 按 Profile 在读写时注入故障的 net.Conn 。
+type FaultConn struct {
+	net.Conn
+	profile Profile
+	start   time.Time
+
+	mutex         sync.Mutex
+	read          int64
+	written       int64
+	reset         bool
+	readDeadline  time.Time
+	writeDeadline time.Time
+}
+
+func NewFaultConn(conn net.Conn, profile Profile) *FaultConn {
+	return &FaultConn{
+		Conn:    conn,
+		profile: profile,
+		start:   time.Now(),
+	}
+}
+
+// faults 返回这次读写要注入的故障，以及对应的时限。连接已经被重置时返回错误。
+func (c *FaultConn) faults(write bool) (Faults, time.Time, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.reset {
+		return Faults{}, time.Time{}, c.resetError(write)
+	}
+	faults := c.profile(Op{Write: write, Elapsed: time.Since(c.start), Read: c.read, Written: c.written})
+	if faults.Reset {
+		c.reset = true
+		_ = c.Conn.Close()
+		return Faults{}, time.Time{}, c.resetError(write)
+	}
+	if write {
+		return faults, c.writeDeadline, nil
+	}
+	return faults, c.readDeadline, nil
+}
+
+func (c *FaultConn) resetError(write bool) error {
+	op := "read"
+	if write {
+		op = "write"
+	}
+	return &net.OpError{Op: op, Net: "tcp", Source: c.LocalAddr(), Addr: c.RemoteAddr(), Err: syscall.ECONNRESET}
+}
+
+func (c *FaultConn) Read(b []byte) (int, error) {
+	faults, deadline, err := c.faults(false)
+	if err != nil {
+		return 0, err
+	}
+	if err := sleep(delay(faults), deadline); err != nil {
+		return 0, err
+	}
+	if faults.MaxReadSize > 0 && len(b) > faults.MaxReadSize {
+		b = b[:faults.MaxReadSize]
+	}
+	if faults.ReadBandwidth > 0 && len(b) > chunkSize(faults.ReadBandwidth) {
+		b = b[:chunkSize(faults.ReadBandwidth)]
+	}
+	n, err := c.Conn.Read(b)
+	c.mutex.Lock()
+	c.read += int64(n)
+	c.mutex.Unlock()
+	if err == nil && faults.ReadBandwidth > 0 {
+		// 按带宽补足这次读取应该花的时间。数据已经读到了，所以不检查时限。
+		time.Sleep(transferTime(n, faults.ReadBandwidth))
+	}
+	return n, err
+}
+
+func (c *FaultConn) Write(b []byte) (int, error) {
+	faults, deadline, err := c.faults(true)
+	if err != nil {
+		return 0, err
+	}
+	if faults.WriteTimeout {
+		if !deadline.IsZero() {
+			time.Sleep(time.Until(deadline))
+		}
+		return 0, os.ErrDeadlineExceeded
+	}
+	if err := sleep(delay(faults), deadline); err != nil {
+		return 0, err
+	}
+	if faults.MaxWriteSize > 0 && len(b) > faults.MaxWriteSize {
+		b = b[:faults.MaxWriteSize]
+	}
+	if faults.WriteBandwidth == 0 {
+		return c.write(b)
+	}
+	// 限制带宽时分成小块写入，每块之前等待传输的时间，超过时限时返回已经写入的字节数。
+	written := 0
+	for len(b) > 0 {
+		chunk := b
+		if len(chunk) > chunkSize(faults.WriteBandwidth) {
+			chunk = chunk[:chunkSize(faults.WriteBandwidth)]
+		}
+		if err := sleep(transferTime(len(chunk), faults.WriteBandwidth), deadline); err != nil {
+			return written, err
+		}
+		n, err := c.write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		b = b[n:]
+	}
+	return written, nil
+}
+
+func (c *FaultConn) write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.mutex.Lock()
+	c.written += int64(n)
+	c.mutex.Unlock()
+	return n, err
+}
+
+func (c *FaultConn) SetDeadline(t time.Time) error {
+	c.mutex.Lock()
+	c.readDeadline, c.writeDeadline = t, t
+	c.mutex.Unlock()
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *FaultConn) SetReadDeadline(t time.Time) error {
+	c.mutex.Lock()
+	c.readDeadline = t
+	c.mutex.Unlock()
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *FaultConn) SetWriteDeadline(t time.Time) error {
+	c.mutex.Lock()
+	c.writeDeadline = t
+	c.mutex.Unlock()
+	return c.Conn.SetWriteDeadline(t)
+}
+
+// FaultListener 给 Accept 的每个连接包上 FaultConn 。可以用 tcp.Server.Serve 在它上面提供服务。
+type FaultListener struct {
+	net.Listener
+	profile Profile
+}
+
+func NewFaultListener(ln net.Listener, profile Profile) *FaultListener {
+	return &FaultListener{Listener: ln, profile: profile}
+}
+
+func (l *FaultListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return NewFaultConn(conn, l.profile), nil
+}
+
+func delay(faults Faults) time.Duration {
+	d := faults.Latency
+	if faults.Jitter > 0 {
+		d += time.Duration(rand.Int63n(int64(faults.Jitter)))
+	}
+	return d
+}
+
+// chunkSize 是限制带宽时每次读写的字节数，大约是 50ms 能传输的量。
+func chunkSize(bandwidth int) int {
+	if size := bandwidth / 20; size > 0 {
+		return size
+	}
+	return 1
+}
+
+func transferTime(n int, bandwidth int) time.Duration {
+	return time.Duration(n) * time.Second / time.Duration(bandwidth)
+}
+
+// sleep 等待 d 。会超过 deadline 时等到 deadline 然后返回 os.ErrDeadlineExceeded 。
+func sleep(d time.Duration, deadline time.Time) error {
+	if d <= 0 {
+		return nil
+	}
+	if !deadline.IsZero() && time.Until(deadline) < d {
+		time.Sleep(time.Until(deadline))
+		return os.ErrDeadlineExceeded
+	}
+	time.Sleep(d)
+	return nil
+}
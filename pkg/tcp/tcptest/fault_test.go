@@ -0,0 +1,88 @@
+package tcptest
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestNewFaultServer(t *testing.T) {
+	// 服务端每次最多读2字节、写3字节，Receiver 要重组，Sender 要循环写完。
+	ts := NewFaultServer(newEchoServer(), Constant(Faults{MaxReadSize: 2, MaxWriteSize: 3, Latency: time.Millisecond}))
+	defer ts.Close()
+	client, err := ts.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.SetSplitter(lineSplitter)
+	for i := 0; i < 3; i++ {
+		line := fmt.Sprintf("line %d of the test", i)
+		if err := client.Write([]byte(line + "\n")); err != nil {
+			t.Fatal(err)
+		}
+		if frame, err := client.NextFrame(); err != nil || string(frame) != line {
+			t.Errorf("got %q, %v, want %q", frame, err, line)
+		}
+	}
+}
+
+func TestNewFaultServer_reset(t *testing.T) {
+	ts := NewFaultServer(newEchoServer(), Script(Step{AfterBytes: 6, Faults: Faults{Reset: true}}))
+	defer ts.Close()
+	client, err := ts.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if err := client.Write([]byte("hello\n")); err != nil {
+		t.Fatal(err)
+	}
+	if reason, err := client.WaitClosed(); err != nil || !errors.Is(reason, syscall.ECONNRESET) {
+		t.Errorf("got reason %v, %v", reason, err)
+	}
+}
+
+func TestFaultConn(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+	conn := NewFaultConn(local, Script(
+		Step{Faults: Faults{Latency: 20 * time.Millisecond}},
+		Step{AfterBytes: 1, Faults: Faults{WriteTimeout: true}},
+	))
+	go func() { _, _ = remote.Read(make([]byte, 1)) }()
+	start := time.Now()
+	if n, err := conn.Write([]byte("a")); n != 1 || err != nil {
+		t.Fatalf("write got %d, %v", n, err)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("write took %v", elapsed)
+	}
+	_ = conn.SetWriteDeadline(time.Now().Add(20 * time.Millisecond))
+	if _, err := conn.Write([]byte("b")); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("write after the first byte got %v", err)
+	}
+}
+
+func TestFaultConn_bandwidth(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+	go func() {
+		buf := make([]byte, 100)
+		for {
+			if _, err := remote.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+	// 每秒200字节，每块10字节，30字节需要150ms ，超过时限时返回已经写入的字节数。
+	conn := NewFaultConn(local, Constant(Faults{WriteBandwidth: 200}))
+	_ = conn.SetWriteDeadline(time.Now().Add(80 * time.Millisecond))
+	if n, err := conn.Write(make([]byte, 30)); n == 0 || n == 30 || !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("got %d, %v", n, err)
+	}
+}
@@ -0,0 +1,85 @@
+// Package tcptest 提供在内存里测试 tcp.Server 的工具，不需要监听端口。参考 net/http/httptest 。
+package tcptest
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// Listener 是内存里的 net.Listener 。Dial 用 net.Pipe 创建一对连接，服务端一侧由 Accept 返回。
+// 每个连接的客户端地址都不同，形如 127.0.0.1:10001 ，所以按远端地址区分连接的代码也能正常工作。
+type Listener struct {
+	conns  chan net.Conn
+	closed chan struct{}
+	once   sync.Once
+	dialed atomic.Int32
+}
+
+func NewListener() *Listener {
+	return &Listener{
+		conns:  make(chan net.Conn),
+		closed: make(chan struct{}),
+	}
+}
+
+// Accept 等待下一个 Dial 的连接。Listener 关闭后返回 net.ErrClosed 。
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *Listener) Close() error {
+	l.once.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *Listener) Addr() net.Addr {
+	return listenerAddr
+}
+
+var listenerAddr = &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 10000}
+
+// Dial 创建一个连接，返回客户端一侧。等到服务端 Accept 之后才返回。
+func (l *Listener) Dial() (net.Conn, error) {
+	return l.DialContext(context.Background(), "tcp", listenerAddr.String())
+}
+
+// DialContext 和 Dial 一样，签名和 net.Dialer.DialContext 相同，可以替换其他代码里的拨号函数。network 和 address 被忽略。
+func (l *Listener) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	clientAddr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 10000 + int(l.dialed.Add(1))}
+	client, server := net.Pipe()
+	serverConn := &addrConn{Conn: server, local: listenerAddr, remote: clientAddr}
+	select {
+	case l.conns <- serverConn:
+		return &addrConn{Conn: client, local: clientAddr, remote: listenerAddr}, nil
+	case <-l.closed:
+		_ = client.Close()
+		_ = server.Close()
+		return nil, net.ErrClosed
+	case <-ctx.Done():
+		_ = client.Close()
+		_ = server.Close()
+		return nil, ctx.Err()
+	}
+}
+
+// addrConn 替换 net.Pipe 的地址。
+type addrConn struct {
+	net.Conn
+	local  net.Addr
+	remote net.Addr
+}
+
+func (c *addrConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+func (c *addrConn) RemoteAddr() net.Addr {
+	return c.remote
+}
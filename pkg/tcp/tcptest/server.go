@@ -0,0 +1,82 @@
+package tcptest
+
+import (
+	"github.com/seedjyh/go-tcp/pkg/tcp"
+	"net"
+	"sync"
+	"time"
+)
+
+// DefaultTimeout 是 Client 等待的默认时限。
+const DefaultTimeout = time.Second
+
+// Server 在内存里的 Listener 上运行一个配置好的 tcp.Server 。
+type Server struct {
+	Listener *Listener
+	done     chan error
+	mutex    sync.Mutex
+	reasons  map[string]chan error // 按客户端地址记录 Dial 的连接结束的原因，连接结束后删除
+}
+
+// NewServer 开始在内存里运行 s 。s 应该已经配置好，测试结束时要调用 Close 。
+// 它会用 s.SetOnClosed 记录连接结束的原因，之前设置的 OnClosedFunc 仍会先被调用，所以之后不要再调用 s.SetOnClosed 。
+func NewServer(s *tcp.Server) *Server {
+	return newServer(s, nil)
+}
+
+// NewFaultServer 和 NewServer 一样，但是服务端一侧的连接按 profile 注入故障，见 FaultConn 。
+func NewFaultServer(s *tcp.Server, profile Profile) *Server {
+	return newServer(s, profile)
+}
+
+func newServer(s *tcp.Server, profile Profile) *Server {
+	ts := &Server{
+		Listener: NewListener(),
+		done:     make(chan error, 1),
+		reasons:  make(map[string]chan error),
+	}
+	previous := s.OnClosed()
+	s.SetOnClosed(func(info *tcp.ConnectionInfo, reason error) {
+		if previous != nil {
+			previous(info, reason)
+		}
+		ts.closed(info.RemoteAddr.String(), reason)
+	})
+	var ln net.Listener = ts.Listener
+	if profile != nil {
+		ln = NewFaultListener(ln, profile)
+	}
+	go func() { ts.done <- s.Serve(ln) }()
+	return ts
+}
+
+// closed 把 addr 连接结束的原因交给 Dial 返回的 Client 。不是 Dial 的连接直接忽略。
+func (s *Server) closed(addr string, reason error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if ch, ok := s.reasons[addr]; ok {
+		ch <- reason
+		delete(s.reasons, addr)
+	}
+}
+
+// Dial 连接到 Server 。
+func (s *Server) Dial() (*Client, error) {
+	// 连接可能在 Listener.Dial 返回之前就结束了，所以拿着锁直到记录好 channel ， closed 会等待。
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	conn, err := s.Listener.Dial()
+	if err != nil {
+		return nil, err
+	}
+	reason := make(chan error, 1)
+	s.reasons[conn.LocalAddr().String()] = reason
+	return newClient(conn, reason), nil
+}
+
+// Close 停止 Server ，等待所有连接结束。
+// 关闭 Listener 和 tcp.Server.Stop 的效果一样，而且不用等 Serve 开始运行。
+func (s *Server) Close() error {
+	_ = s.Listener.Close()
+	return <-s.done
+}
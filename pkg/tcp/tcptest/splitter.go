@@ -0,0 +1,204 @@
+package tcptest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/seedjyh/go-tcp/pkg/tcp"
+	"math/rand"
+	"testing"
+)
+
+// CheckSplitter 检查 splitter 和 encode 是否配对，以及 splitter 是否遵守 tcp.SplitterFunc 的约定。返回发现的第一个问题。
+//
+// encode 把 payload 编码成一帧，splitter 应该从帧里拆分出原来的 payload ，例如 tcp.LengthPrefixSplitter 和 tcp.LengthPrefixFramer 。
+// 检查的内容包括：
+//   - 每一帧单独拆分时，读取的长度等于帧的长度，Packet 等于 payload ；
+//   - 帧的每一个不完整的前缀都返回 tcp.NoEnoughData ；
+//   - 所有帧连在一起，按任意边界（包括逐字节）分多次到达时，按顺序拆分出所有 payload ，没有剩余字节；
+//   - 每次调用：不 panic ，不修改 buf ，读取的长度在 1 到 len(buf) 之间，同样的输入返回同样的结果。
+func CheckSplitter(splitter tcp.SplitterFunc, encode tcp.FramerFunc, payloads ...[]byte) error {
+	var stream []byte
+	for i, payload := range payloads {
+		frame := encode(payload)
+		for end := 0; end < len(frame); end++ {
+			if _, _, err := split(splitter, frame[:end]); !errors.Is(err, tcp.NoEnoughData) {
+				return fmt.Errorf("payload %d: prefix of %d/%d bytes: got %v, want NoEnoughData", i, end, len(frame), err)
+			}
+		}
+		if packet, n, err := split(splitter, frame); err != nil {
+			return fmt.Errorf("payload %d: %w", i, err)
+		} else if n != len(frame) {
+			return fmt.Errorf("payload %d: consumed %d of %d bytes", i, n, len(frame))
+		} else if !bytes.Equal(packet.Bytes(), payload) {
+			return fmt.Errorf("payload %d: got %q, want %q", i, packet.Bytes(), payload)
+		}
+		stream = append(stream, frame...)
+	}
+	random := rand.New(rand.NewSource(int64(len(stream))))
+	chunkings := [][]int{{len(stream)}}
+	for _, size := range []int{1, 2, 3, 7} {
+		chunkings = append(chunkings, fixedChunks(len(stream), size))
+	}
+	for i := 0; i < 8; i++ {
+		chunkings = append(chunkings, randomChunks(random, len(stream)))
+	}
+	for _, chunks := range chunkings {
+		if got, err := reassemble(splitter, stream, chunks); err != nil {
+			return fmt.Errorf("chunks %v: %w", chunks, err)
+		} else if len(got) != len(payloads) {
+			return fmt.Errorf("chunks %v: got %d payloads, want %d", chunks, len(got), len(payloads))
+		} else {
+			for i := range got {
+				if !bytes.Equal(got[i], payloads[i]) {
+					return fmt.Errorf("chunks %v: payload %d: got %q, want %q", chunks, i, got[i], payloads[i])
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// CheckSplitterInput 用任意的 buf 调用 splitter ，检查每次调用的约定（见 CheckSplitter ），并且拆分完所有能拆分的帧。
+// 它不要求 buf 是合法的帧，用来检查 splitter 能否安全地处理恶意或者损坏的数据。
+func CheckSplitterInput(splitter tcp.SplitterFunc, buf []byte) error {
+	for len(buf) > 0 {
+		_, n, err := split(splitter, buf)
+		if err != nil {
+			var violation *splitterViolation
+			if errors.As(err, &violation) {
+				return err
+			}
+			return nil
+		}
+		buf = buf[n:]
+	}
+	return nil
+}
+
+// FuzzSplitter 用 f 对 splitter 做模糊测试。在 Fuzz 函数里调用：
+//
+//	func FuzzMySplitter(f *testing.F) {
+//		tcptest.FuzzSplitter(f, mySplitter, myFramer, []byte("seed"))
+//	}
+//
+// 每个输入都用 CheckSplitterInput 检查。encode 不是 nil 时，还把输入拆成两个 payload 用 CheckSplitter 检查，
+// 但是编码后的帧被 splitter 拒绝时（例如超过长度上限）跳过这一项。
+func FuzzSplitter(f *testing.F, splitter tcp.SplitterFunc, encode tcp.FramerFunc, seeds ...[]byte) {
+	for _, seed := range seeds {
+		f.Add(seed, uint8(len(seed)/2))
+	}
+	f.Fuzz(func(t *testing.T, data []byte, cut uint8) {
+		if err := CheckSplitterInput(splitter, data); err != nil {
+			t.Fatal(err)
+		}
+		if encode == nil {
+			return
+		}
+		at := int(cut)
+		if at > len(data) {
+			at = len(data)
+		}
+		payloads := [][]byte{data[:at], data[at:]}
+		for _, payload := range payloads {
+			var violation *splitterViolation
+			if _, _, err := split(splitter, encode(payload)); err != nil && !errors.Is(err, tcp.NoEnoughData) && !errors.As(err, &violation) {
+				t.Skip(err)
+			}
+		}
+		if err := CheckSplitter(splitter, encode, payloads...); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+// splitterViolation 表示 splitter 违反了 tcp.SplitterFunc 的约定，而不是正常地返回错误。
+type splitterViolation struct {
+	message string
+}
+
+func (v *splitterViolation) Error() string {
+	return v.message
+}
+
+func violationf(format string, args ...interface{}) error {
+	return &splitterViolation{message: fmt.Sprintf(format, args...)}
+}
+
+// split 调用 splitter 并检查这一次调用的约定，违反约定时返回 *splitterViolation 。
+func split(splitter tcp.SplitterFunc, buf []byte) (*tcp.Packet, int, error) {
+	input := append([]byte(nil), buf...)
+	packet, n, err := call(splitter, input)
+	if v, ok := err.(*splitterViolation); ok {
+		return nil, 0, v
+	}
+	if !bytes.Equal(input, buf) {
+		return nil, 0, violationf("splitter modified its input of %d bytes", len(buf))
+	}
+	if err == nil {
+		if packet == nil {
+			return nil, 0, violationf("splitter returned a nil packet without error")
+		}
+		if n <= 0 || n > len(buf) {
+			return nil, 0, violationf("splitter consumed %d of %d bytes", n, len(buf))
+		}
+	}
+	againPacket, againN, againErr := call(splitter, append([]byte(nil), buf...))
+	if (err == nil) != (againErr == nil) || errors.Is(err, tcp.NoEnoughData) != errors.Is(againErr, tcp.NoEnoughData) ||
+		n != againN || (err == nil && !bytes.Equal(packet.Bytes(), againPacket.Bytes())) {
+		return nil, 0, violationf("splitter is not deterministic on %d bytes: (%d, %v) then (%d, %v)", len(buf), n, err, againN, againErr)
+	}
+	return packet, n, err
+}
+
+// call 调用 splitter ，把 panic 转成 *splitterViolation 。
+func call(splitter tcp.SplitterFunc, buf []byte) (packet *tcp.Packet, n int, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = violationf("splitter panicked on %d bytes: %v", len(buf), r)
+		}
+	}()
+	return splitter(buf)
+}
+
+// reassemble 模拟 tcp.Receiver ：stream 按 chunks 分多次到达，每次到达后拆分出所有完整的帧。
+func reassemble(splitter tcp.SplitterFunc, stream []byte, chunks []int) ([][]byte, error) {
+	var buf []byte
+	var payloads [][]byte
+	for _, size := range chunks {
+		buf = append(buf, stream[:size]...)
+		stream = stream[size:]
+		for len(buf) > 0 {
+			packet, n, err := split(splitter, buf)
+			if errors.Is(err, tcp.NoEnoughData) {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+			payloads = append(payloads, packet.Bytes())
+			buf = buf[n:]
+		}
+	}
+	if len(buf) > 0 {
+		return nil, fmt.Errorf("%d bytes left after the last frame", len(buf))
+	}
+	return payloads, nil
+}
+
+func fixedChunks(total, size int) []int {
+	var chunks []int
+	for ; total > size; total -= size {
+		chunks = append(chunks, size)
+	}
+	return append(chunks, total)
+}
+
+func randomChunks(random *rand.Rand, total int) []int {
+	var chunks []int
+	for total > 0 {
+		size := 1 + random.Intn(total)
+		chunks = append(chunks, size)
+		total -= size
+	}
+	return chunks
+}
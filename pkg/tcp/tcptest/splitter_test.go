@@ -0,0 +1,75 @@
+package tcptest
+
+import (
+	"errors"
+	"github.com/seedjyh/go-tcp/pkg/tcp"
+	"strings"
+	"testing"
+)
+
+func lineFramer(payload []byte) []byte {
+	return append(append([]byte(nil), payload...), '\n')
+}
+
+func TestCheckSplitter(t *testing.T) {
+	payloads := [][]byte{[]byte("hello"), {}, []byte(strings.Repeat("x", 300))}
+	if err := CheckSplitter(tcp.LengthPrefixSplitter(1024), tcp.LengthPrefixFramer, payloads...); err != nil {
+		t.Error(err)
+	}
+	if err := CheckSplitter(lineSplitter, lineFramer, []byte("a"), []byte("bc")); err != nil {
+		t.Error(err)
+	}
+	for name, splitter := range map[string]tcp.SplitterFunc{
+		"consumes too much": func(buf []byte) (*tcp.Packet, int, error) {
+			packet, n, err := lineSplitter(buf)
+			return packet, n + 1, err
+		},
+		"mutates input": func(buf []byte) (*tcp.Packet, int, error) {
+			packet, n, err := lineSplitter(buf)
+			if err == nil {
+				buf[n-1] = 0
+			}
+			return packet, n, err
+		},
+		"ignores partial frames": func(buf []byte) (*tcp.Packet, int, error) {
+			if len(buf) == 0 {
+				return nil, 0, tcp.NoEnoughData
+			}
+			return tcp.NewPacket(buf), len(buf), nil
+		},
+		"panics": func(buf []byte) (*tcp.Packet, int, error) {
+			return tcp.NewPacket(buf[:1]), 1, nil
+		},
+	} {
+		if err := CheckSplitter(splitter, lineFramer, []byte("a"), []byte("bc")); err == nil {
+			t.Errorf("%s: no problem found", name)
+		}
+	}
+}
+
+func TestServer_badSplitter(t *testing.T) {
+	s := newEchoServer()
+	s.SetSplitter(func(buf []byte) (*tcp.Packet, int, error) {
+		if len(buf) == 0 {
+			return nil, 0, tcp.NoEnoughData
+		}
+		return tcp.NewPacket(buf), len(buf) + 1, nil
+	})
+	ts := NewServer(s)
+	defer ts.Close()
+	client, err := ts.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if err := client.Write([]byte("hello\n")); err != nil {
+		t.Fatal(err)
+	}
+	if reason, err := client.WaitClosed(); err != nil || !errors.Is(reason, tcp.BadSplitter) {
+		t.Errorf("got reason %v, %v", reason, err)
+	}
+}
+
+func FuzzLengthPrefixSplitter(f *testing.F) {
+	FuzzSplitter(f, tcp.LengthPrefixSplitter(64), tcp.LengthPrefixFramer, []byte("hello"), []byte{0, 0, 0, 1, 'x'}, nil)
+}
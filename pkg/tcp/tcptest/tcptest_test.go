@@ -0,0 +1,131 @@
+package tcptest
+
+import (
+	"bytes"
+	"errors"
+	"github.com/seedjyh/go-tcp/pkg/tcp"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func lineSplitter(buf []byte) (*tcp.Packet, int, error) {
+	if i := bytes.IndexByte(buf, '\n'); i >= 0 {
+		return tcp.NewPacket(buf[:i]), i + 1, nil
+	}
+	return nil, 0, tcp.NoEnoughData
+}
+
+func newEchoServer() *tcp.Server {
+	s := tcp.NewServer()
+	s.SetLogHandler(slog.NewTextHandler(io.Discard, nil))
+	s.SetSplitter(lineSplitter)
+	s.SetDefaultHandler(func(c tcp.Context) error {
+		received := c.Received().(*tcp.Packet).Bytes()
+		if string(received) == "bye" {
+			c.Close()
+			return nil
+		}
+		c.Send(tcp.NewPacket(append(received, '\n')))
+		return nil
+	})
+	return s
+}
+
+func TestServer(t *testing.T) {
+	ts := NewServer(newEchoServer())
+	defer func() {
+		if err := ts.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+	client, err := ts.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.SetSplitter(lineSplitter)
+	// 逐字节发送，每一帧都被拆到多次 Read 里
+	if err := client.WriteChunks([]byte("hello\nworld\n"), 1); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"hello", "world"} {
+		if frame, err := client.NextFrame(); err != nil || string(frame) != want {
+			t.Errorf("got %q, %v, want %q", frame, err, want)
+		}
+	}
+	if err := client.Write([]byte("bye\n")); err != nil {
+		t.Fatal(err)
+	}
+	if reason, err := client.WaitClosed(); err != nil || !errors.Is(reason, tcp.ConnectionClosedByHandler) {
+		t.Errorf("got reason %v, %v", reason, err)
+	}
+	if _, err := client.NextFrame(); !errors.Is(err, io.EOF) {
+		t.Errorf("after close got %v", err)
+	}
+}
+
+func TestServer_clients(t *testing.T) {
+	ts := NewServer(newEchoServer())
+	defer ts.Close()
+	a, err := ts.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ts.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Conn().LocalAddr().String() == b.Conn().LocalAddr().String() {
+		t.Error("clients share the same address")
+	}
+	_ = a.Close()
+	if reason, err := a.WaitClosed(); err != nil || tcp.DisconnectReason(reason) != "eof" {
+		t.Errorf("got reason %v, %v", reason, err)
+	}
+	b.Timeout = 50 * time.Millisecond
+	if _, err := b.WaitClosed(); !errors.Is(err, Timeout) {
+		t.Errorf("other client got %v", err)
+	}
+	b.Timeout = DefaultTimeout
+	if err := b.Write([]byte("still here\n")); err != nil {
+		t.Fatal(err)
+	}
+	if frame, err := b.NextFrame(); err != nil || string(frame) != "still here\n" {
+		t.Errorf("got %q, %v", frame, err)
+	}
+}
+
+func TestNewServer_onClosed(t *testing.T) {
+	s := newEchoServer()
+	closed := make(chan error, 2)
+	s.SetOnClosed(func(info *tcp.ConnectionInfo, reason error) {
+		closed <- reason
+	})
+	ts := NewServer(s)
+	defer ts.Close()
+	client, err := ts.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 不是用 ts.Dial 建立的连接不记录原因
+	other, err := ts.Listener.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = other.Close()
+	<-closed
+	_ = client.Close()
+	if _, err := client.WaitClosed(); err != nil {
+		t.Fatal(err)
+	}
+	if reason := <-closed; tcp.DisconnectReason(reason) != "eof" {
+		t.Errorf("previous OnClosed got %v", reason)
+	}
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+	if len(ts.reasons) != 0 {
+		t.Errorf("%d closed connections are still recorded", len(ts.reasons))
+	}
+}
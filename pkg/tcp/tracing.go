@@ -0,0 +1,41 @@
+package tcp
+
+import "context"
+
+// Tracer 为每条消息创建一个 Span 。用 Server.SetTracer 启用。
+// oteltrace 子包提供了基于 OpenTelemetry 的实现。
+type Tracer interface {
+	// Start 创建一个 Span ，返回的 context.Context 携带这个 Span ，会通过 Context.Context 交给中间件和处理函数。
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span 是一条消息的处理过程。
+type Span interface {
+	SetAttribute(key string, value interface{})
+	// End 结束这个 Span 。err 是处理结果，nil 表示成功。
+	End(err error)
+}
+
+// MessageSpanName 是每条消息的 Span 的名字。
+const MessageSpanName = "tcp.message"
+
+// Span 的属性名。
+const (
+	SpanAttributeConnectionID = "tcp.connection_id"
+	SpanAttributeRemoteAddr   = "tcp.remote_addr"
+	SpanAttributeFrameSize    = "tcp.frame_size"
+	SpanAttributeRoute        = "tcp.route"
+	SpanAttributeOutcome      = "tcp.outcome" // 取值是 "ok", "error" 或 "panic"
+)
+
+// nopTracer 不记录任何东西。是 Server 的默认值。
+type nopTracer struct{}
+
+func (nopTracer) Start(ctx context.Context, _ string) (context.Context, Span) {
+	return ctx, nopSpan{}
+}
+
+type nopSpan struct{}
+
+func (nopSpan) SetAttribute(string, interface{}) {}
+func (nopSpan) End(error)                        {}
@@ -3,3 +3,10 @@ package tcp
 type Generator interface {
 	Next() string
 }
+
+// FallibleGenerator 是生成 ID 可能出错的 Generator ，例如随机数来源出错。
+// Listener 用 Generate 生成连接 ID ，出错时 Server 以 RejectReasonConnectionID 拒绝这个连接。
+type FallibleGenerator interface {
+	Generator
+	Generate() (string, error)
+}
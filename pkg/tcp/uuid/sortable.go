@@ -0,0 +1,208 @@
+package uuid
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// InvalidID 是 Parse 和 ParseUUID32 遇到格式不对的 ID 时返回的错误。
+var InvalidID = errors.New("uuid: invalid id")
+
+const (
+	maxSequence        = 1<<12 - 1 // 一毫秒内最大的序号
+	sequenceStartLimit = 1 << 11   // 每毫秒的序号从 [0, sequenceStartLimit) 里随机开始，留出一半的空间递增
+)
+
+// Config 是 SortableGenerator 的配置。零值就是可用的默认配置。
+type Config struct {
+	// Random 是随机部分的来源。nil 表示用 math/rand 的全局函数（随机播种，不加锁），足够避免不同进程的 ID 雷同，但是可以被猜测。
+	// 需要 ID 不可预测时（例如把它当作凭证）用 crypto/rand.Reader 。
+	// 读取出错时不会改用 math/rand ：Generate 返回错误， Next panic 。
+	Random io.Reader
+	// Now 是时间来源。nil 表示 time.Now 。
+	Now func() time.Time
+	// Machine 是写入 ID 的机器码，只使用低3字节。0表示 hostname 的 CRC32 校验和。
+	Machine uint32
+	// PID 是写入 ID 的进程号。0表示当前进程号的低2字节。
+	PID uint16
+}
+
+// SortableGenerator 生成按时间排序的 ID ，长度32，小写16进制，和 UUIDv7 （RFC 9562）的二进制格式兼容：
+//
+//	[0]~[5]   6 bytes: unix 毫秒
+//	[6]~[7]   2 bytes: 高4位是版本号7，低12位是毫秒内的递增序号
+//	[8]       1 byte : 高2位是变体10，低6位随机
+//	[9]~[11]  3 bytes: 机器码
+//	[12]~[13] 2 bytes: 进程号
+//	[14]~[15] 2 bytes: 随机
+//
+// 同一个生成器生成的 ID 按字符串比较严格递增：一毫秒内的序号用完，或者时钟回拨时，沿用上一个时间戳继续递增。
+// 可以并发调用。时间戳和序号放在一个原子变量里用 CAS 更新，只有 Config.Random 不是 nil 时读随机数需要加锁。
+type SortableGenerator struct {
+	state       atomic.Uint64 // 高48位是最近一次的 unix 毫秒，低16位是序号
+	randomMutex sync.Mutex    // 保护 random
+	random      io.Reader     // nil 表示用 math/rand 的全局函数
+	now         func() time.Time
+	machine     [3]byte
+	pid         [2]byte
+}
+
+func NewSortableGenerator(config Config) *SortableGenerator {
+	g := &SortableGenerator{
+		random:  config.Random,
+		now:     config.Now,
+		machine: getMachineCode(),
+		pid:     getPidCode(),
+	}
+	if g.now == nil {
+		g.now = time.Now
+	}
+	if config.Machine != 0 {
+		g.machine = [3]byte{byte(config.Machine >> 16), byte(config.Machine >> 8), byte(config.Machine)}
+	}
+	if config.PID != 0 {
+		binary.BigEndian.PutUint16(g.pid[:], config.PID)
+	}
+	return g
+}
+
+// NewDeterministicGenerator 返回用于测试的 SortableGenerator ：同样的 seed 总是生成同样的 ID 序列。
+// 时间从 2000-01-01 UTC 开始，每生成一个 ID 增加1毫秒；机器码和进程号是0。
+// 并发调用是安全的，但是各个协程拿到哪些 ID 取决于调度。
+func NewDeterministicGenerator(seed int64) *SortableGenerator {
+	start := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	var calls atomic.Int64
+	g := NewSortableGenerator(Config{
+		Random: rand.New(rand.NewSource(seed)),
+		Now: func() time.Time {
+			return start.Add(time.Duration(calls.Add(1)) * time.Millisecond)
+		},
+	})
+	g.machine, g.pid = [3]byte{}, [2]byte{}
+	return g
+}
+
+// Next 生成一个 ID 。 Config.Random 读取出错时 panic ，需要处理错误时用 Generate 。
+// 作为 Server 的连接 ID 生成器时， Server 调用的是 Generate ，出错时拒绝这个连接。
+func (g *SortableGenerator) Next() string {
+	if id, err := g.Generate(); err != nil {
+		panic(err)
+	} else {
+		return id
+	}
+}
+
+// Generate 生成一个 ID 。 Config.Random 读取出错时返回错误。
+func (g *SortableGenerator) Generate() (string, error) {
+	// 随机部分：[8] 的低6位、 [14]~[15] ，以及序号的起点
+	random, err := g.readRandom()
+	if err != nil {
+		return "", err
+	}
+	ms, sequence := g.nextState(uint64(binary.BigEndian.Uint16(random[3:]) % sequenceStartLimit))
+	var buf [16]byte
+	binary.BigEndian.PutUint64(buf[:8], ms<<16|0x7000|sequence)
+	buf[8] = 0x80 | random[0]&0x3f
+	copy(buf[9:12], g.machine[:])
+	copy(buf[12:14], g.pid[:])
+	copy(buf[14:16], random[1:3])
+	return hex.EncodeToString(buf[:]), nil
+}
+
+// nextState 递增 g.state ，返回这个 ID 的毫秒和序号。进入新的一毫秒时序号从 start 开始。
+func (g *SortableGenerator) nextState(start uint64) (ms uint64, sequence uint64) {
+	now := uint64(g.now().UnixMilli())
+	for {
+		old := g.state.Load()
+		lastMs, lastSequence := old>>16, old&0xffff
+		var next uint64
+		switch {
+		case now > lastMs:
+			next = now<<16 | start
+		case lastSequence < maxSequence:
+			next = old + 1
+		default:
+			// 这一毫秒的序号用完了，借用下一毫秒
+			next = (lastMs+1)<<16 | start
+		}
+		if g.state.CompareAndSwap(old, next) {
+			return next >> 16, next & 0xffff
+		}
+	}
+}
+
+func (g *SortableGenerator) readRandom() ([5]byte, error) {
+	var random [5]byte
+	if g.random == nil {
+		r := rand.Uint64()
+		return [5]byte{byte(r >> 32), byte(r >> 24), byte(r >> 16), byte(r >> 8), byte(r)}, nil
+	}
+	g.randomMutex.Lock()
+	defer g.randomMutex.Unlock()
+	if _, err := io.ReadFull(g.random, random[:]); err != nil {
+		return random, fmt.Errorf("uuid: read random: %w", err)
+	}
+	return random, nil
+}
+
+// Fields 是从 ID 里解析出的字段。
+type Fields struct {
+	Time     time.Time // SortableGenerator 精确到毫秒，uuid32 格式精确到秒
+	Machine  uint32    // 机器码，hostname 的 CRC32 校验和的低3字节
+	PID      uint16    // 进程号的低2字节
+	Sequence uint32    // 同一时间内的递增序号
+}
+
+// Parse 解析 SortableGenerator 生成的 ID 。也接受带连字符的 UUID 标准格式。
+func Parse(id string) (Fields, error) {
+	buf, err := decode(id)
+	if err != nil {
+		return Fields{}, err
+	}
+	if buf[6]>>4 != 7 || buf[8]>>6 != 2 {
+		return Fields{}, InvalidID
+	}
+	head := binary.BigEndian.Uint64(buf[:8])
+	return Fields{
+		Time:     time.UnixMilli(int64(head >> 16)),
+		Machine:  uint32(buf[9])<<16 | uint32(buf[10])<<8 | uint32(buf[11]),
+		PID:      binary.BigEndian.Uint16(buf[12:14]),
+		Sequence: uint32(head & 0x0fff),
+	}, nil
+}
+
+// ParseUUID32 解析 NewUUID32Generator 生成的 ID 。这种格式没有版本号，所以无法检查 id 是不是这种格式。
+func ParseUUID32(id string) (Fields, error) {
+	buf, err := decode(id)
+	if err != nil {
+		return Fields{}, err
+	}
+	return Fields{
+		Time:     time.Unix(int64(binary.BigEndian.Uint32(buf[:4])), 0),
+		Machine:  uint32(buf[4])<<16 | uint32(buf[5])<<8 | uint32(buf[6]),
+		PID:      binary.BigEndian.Uint16(buf[7:9]),
+		Sequence: uint32(buf[9])<<16 | uint32(buf[10])<<8 | uint32(buf[11]),
+	}, nil
+}
+
+func decode(id string) ([16]byte, error) {
+	var buf [16]byte
+	if len(id) == 36 {
+		id = strings.ReplaceAll(id, "-", "")
+	}
+	if len(id) != 32 {
+		return buf, InvalidID
+	}
+	if _, err := hex.Decode(buf[:], []byte(id)); err != nil {
+		return buf, InvalidID
+	}
+	return buf, nil
+}
@@ -7,77 +7,73 @@ import (
 	"hash/crc32"
 	"math/rand"
 	"os"
+	"sync/atomic"
 	"time"
 )
 
 // uuid32Generator 生成长度32的16进制字符串。
 // 生成规则：
-// 1) 先按照下面方式生成16字节（128bit）的整数。
-// 		[0]~[3]   4 bytes: 最近一次更新的unix秒（从1970-01-01开始）
-// 		[4]~[6]   3 bytes: 机器码的低3字节。机器码是hostname的CRC32校验和。
-// 		[7]~[8]   2 bytes: 进程号的低2字节。
-// 		[9]~[11]  3 bytes: 秒内递增数的低3字节。
-// 		[12]~[15] 4 bytes: 随机串，防猜测。
-// 2) 然后每4个bit转化成一个16进制字符。
+//  1. 先按照下面方式生成16字节（128bit）的整数。
+//     [0]~[3]   4 bytes: 最近一次更新的unix秒（从1970-01-01开始）
+//     [4]~[6]   3 bytes: 机器码的低3字节。机器码是hostname的CRC32校验和。
+//     [7]~[8]   2 bytes: 进程号的低2字节。
+//     [9]~[11]  3 bytes: 秒内递增数。每秒从 [0, 2^23) 里随机开始，一秒内用完 2^24 时等到下一秒。
+//     [12]~[15] 4 bytes: 随机串，防猜测。
+//  2. 然后每4个bit转化成一个16进制字符。
+//
+// 字段可以用 ParseUUID32 解析。需要毫秒精度、严格递增的 ID 时用 SortableGenerator 。
+//
+// 可以并发调用 Next 。秒和递增数放在一个原子变量里用 CAS 更新，不需要锁和后台协程，也不需要关闭。
 type uuid32Generator struct {
-	latestSecond     int64
-	increaseInSecond uint32
-	machineCode      [3]byte
-	pidCode          [2]byte
-	idChan           chan string
+	state       atomic.Uint64 // 高32位是最近一次更新的unix秒，低32位是秒内递增数
+	machineCode [3]byte
+	pidCode     [2]byte
 }
 
 func NewUUID32Generator() *uuid32Generator {
-	gen := &uuid32Generator{
-		latestSecond:     0,
-		increaseInSecond: 0,
-		machineCode:      getMachineCode(),
-		pidCode:          getPidCode(),
-		idChan:           make(chan string),
+	return &uuid32Generator{
+		machineCode: getMachineCode(),
+		pidCode:     getPidCode(),
 	}
-	go gen.keepGenerating()
-	return gen
 }
 
 func (g *uuid32Generator) Next() string {
-	return <-g.idChan
+	state := g.nextState()
+	var buf [16]byte
+	// 4 bytes: 秒的低4字节
+	binary.BigEndian.PutUint32(buf[0:], uint32(state>>32))
+	// 3 bytes: machineCode
+	copy(buf[4:7], g.machineCode[:])
+	// 2 bytes: pidCode
+	copy(buf[7:9], g.pidCode[:])
+	// 3 bytes: 递增数的低3字节
+	buf[9], buf[10], buf[11] = byte(state>>16), byte(state>>8), byte(state)
+	// 4 bytes: 随机串，防猜测。Go 1.20 起 math/rand 的全局函数是随机播种的，并且不需要加锁。
+	binary.BigEndian.PutUint32(buf[12:], rand.Uint32())
+	// 转16进制字符串
+	return hex.EncodeToString(buf[:])
 }
 
-func (g *uuid32Generator) keepGenerating() {
+// nextState 递增并返回 g.state 。时钟回拨时沿用之前的秒继续递增，所以同一个生成器不会生成重复的 ID 。
+func (g *uuid32Generator) nextState() uint64 {
 	for {
-		g.updateTimePart()
-		var buf [16]byte
-		offset := 0
-		// 4 bytes: latestSecond的低4字节
-		binary.BigEndian.PutUint32(buf[offset:], uint32(g.latestSecond))
-		offset += 4
-		// 3 bytes: machineCode
-		for _, b := range g.machineCode {
-			buf[offset] = b
-			offset++
+		old := g.state.Load()
+		second, increase := old>>32, old&0xffffffff
+		now := uint64(time.Now().Unix())
+		var next uint64
+		switch {
+		case now > second:
+			next = now<<32 | uint64(rand.Uint32()%maxIncreaseStart)
+		case increase+1 < 1<<24:
+			next = old + 1
+		default:
+			// 3字节的递增数用完了，等到下一秒，避免截断后重复
+			time.Sleep(time.Until(time.Unix(int64(second)+1, 0)))
+			continue
 		}
-		// 2 bytes: pidCode
-		for _, b := range g.pidCode {
-			buf[offset] = b
-			offset++
+		if g.state.CompareAndSwap(old, next) {
+			return next
 		}
-		// 3 bytes: increaseInSecond的低3字节
-		binary.BigEndian.PutUint32(buf[offset:], g.increaseInSecond) // 越界了1字节，不过后续会覆盖
-		offset += 3
-		// 4 bytes: 随机串，防猜测
-		binary.BigEndian.PutUint32(buf[offset:], rand.Uint32())
-		// 转16进制字符串
-		g.idChan <- hex.EncodeToString(buf[:])
-	}
-}
-
-func (g *uuid32Generator) updateTimePart() {
-	now := time.Now().Unix()
-	if g.latestSecond != now {
-		g.latestSecond = now
-		g.increaseInSecond = getIncreaseInSecond()
-	} else {
-		g.increaseInSecond++
 	}
 }
 
@@ -97,7 +93,5 @@ func getPidCode() [2]byte {
 	return pidCode
 }
 
-// getIncreaseInSecond 生成一秒内的随机递增起点
-func getIncreaseInSecond() uint32 {
-	return uint32(rand.Intn(100000000))
-}
+// maxIncreaseStart 是每秒递增数起点的上限，留出一半的空间递增。
+const maxIncreaseStart = 1 << 23
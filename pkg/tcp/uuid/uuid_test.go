@@ -1,8 +1,14 @@
 package uuid
 
 import (
+	cryptorand "crypto/rand"
+	"errors"
 	"fmt"
+	"os"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestNewGenerator(t *testing.T) {
@@ -10,3 +16,155 @@ func TestNewGenerator(t *testing.T) {
 	fmt.Println(c.Next())
 	fmt.Println(c.Next())
 }
+
+func TestParseUUID32(t *testing.T) {
+	g := NewUUID32Generator()
+	first, second := g.Next(), g.Next()
+	a, err := ParseUUID32(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ParseUUID32(second)
+	if time.Since(a.Time) > 2*time.Second || a.PID != uint16(os.Getpid()) || a.Machine != b.Machine {
+		t.Errorf("got %+v", a)
+	}
+	if a.Time.Equal(b.Time) && b.Sequence != a.Sequence+1 {
+		t.Errorf("sequence %d then %d", a.Sequence, b.Sequence)
+	}
+	if _, err := ParseUUID32("xyz"); !errors.Is(err, InvalidID) {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestSortableGenerator(t *testing.T) {
+	// 时钟停在同一毫秒，然后回拨，ID 仍然严格递增
+	now := time.UnixMilli(1700000000000)
+	g := NewSortableGenerator(Config{
+		Random:  cryptorand.Reader,
+		Now:     func() time.Time { return now },
+		Machine: 0xabcdef,
+		PID:     42,
+	})
+	previous := ""
+	for i := 0; i < 10000; i++ {
+		if i == 5000 {
+			now = now.Add(-time.Second)
+		}
+		id := g.Next()
+		if id <= previous {
+			t.Fatalf("%s after %s", id, previous)
+		}
+		previous = id
+	}
+	fields, err := Parse(previous)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 10000 个 ID 至少用掉了2毫秒的序号
+	if !fields.Time.After(time.UnixMilli(1700000000001)) || fields.Machine != 0xabcdef || fields.PID != 42 {
+		t.Errorf("got %+v", fields)
+	}
+	if id := NewSortableGenerator(Config{}).Next(); id[12] != '7' || !strings.ContainsAny(id[16:17], "89ab") {
+		t.Errorf("%s is not a UUIDv7", id)
+	}
+	if _, err := Parse(NewUUID32Generator().Next()); !errors.Is(err, InvalidID) {
+		t.Errorf("uuid32 parsed as sortable: %v", err)
+	}
+}
+
+func TestNewDeterministicGenerator(t *testing.T) {
+	a, b := NewDeterministicGenerator(1), NewDeterministicGenerator(1)
+	for i := 0; i < 3; i++ {
+		if x, y := a.Next(), b.Next(); x != y {
+			t.Errorf("%s != %s", x, y)
+		}
+	}
+	if NewDeterministicGenerator(2).Next() == NewDeterministicGenerator(1).Next() {
+		t.Error("different seeds generate the same id")
+	}
+}
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, errors.New("no entropy")
+}
+
+func TestSortableGenerator_randomError(t *testing.T) {
+	// 随机来源出错时不能悄悄改用 math/rand
+	g := NewSortableGenerator(Config{Random: failingReader{}})
+	if id, err := g.Generate(); err == nil || id != "" {
+		t.Errorf("Generate got %q, %v", id, err)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("Next did not panic")
+		}
+	}()
+	_ = g.Next()
+}
+
+func TestGenerators_concurrent(t *testing.T) {
+	generators := map[string]interface{ Next() string }{
+		"uuid32":        NewUUID32Generator(),
+		"sortable":      NewSortableGenerator(Config{}),
+		"deterministic": NewDeterministicGenerator(1),
+	}
+	for name, g := range generators {
+		t.Run(name, func(t *testing.T) {
+			const workers, n = 8, 2000
+			ids := make(chan string, workers*n)
+			var wg sync.WaitGroup
+			for i := 0; i < workers; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for j := 0; j < n; j++ {
+						ids <- g.Next()
+					}
+				}()
+			}
+			wg.Wait()
+			close(ids)
+			seen := make(map[string]bool, workers*n)
+			for id := range ids {
+				if seen[id] {
+					t.Fatalf("duplicate id %s", id)
+				}
+				seen[id] = true
+			}
+		})
+	}
+}
+
+func BenchmarkUUID32Generator_Next(b *testing.B) {
+	g := NewUUID32Generator()
+	for i := 0; i < b.N; i++ {
+		g.Next()
+	}
+}
+
+func BenchmarkUUID32Generator_Next_parallel(b *testing.B) {
+	g := NewUUID32Generator()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			g.Next()
+		}
+	})
+}
+
+func BenchmarkSortableGenerator_Next(b *testing.B) {
+	g := NewSortableGenerator(Config{})
+	for i := 0; i < b.N; i++ {
+		g.Next()
+	}
+}
+
+func BenchmarkSortableGenerator_Next_parallel(b *testing.B) {
+	g := NewSortableGenerator(Config{})
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			g.Next()
+		}
+	})
+}